@@ -1,6 +1,7 @@
 package tpl
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -24,35 +25,27 @@ func ResolveValueIndex(ctx context.Context, v any, s string) (any, error) {
 	case url.Values:
 		return o[s], nil
 	case Values:
-		n, err := strconv.ParseInt(s, 0, 64)
-		if err != nil {
-			log.Printf("[tpl] failed to access array element #%s", s)
-			return nil, nil
-		}
-		if n < 0 || int(n) >= len(o) {
+		n, ok := seqIndex(s, len(o))
+		if !ok {
 			return nil, nil
 		}
 		return o[n], nil
 	case []any:
-		n, err := strconv.ParseInt(s, 0, 64)
-		if err != nil {
-			log.Printf("[tpl] failed to access array element #%s", s)
-			return nil, nil
-		}
-		if n < 0 || int(n) >= len(o) {
+		n, ok := seqIndex(s, len(o))
+		if !ok {
 			return nil, nil
 		}
 		return o[n], nil
 	case []string:
-		n, err := strconv.ParseInt(s, 0, 64)
-		if err != nil {
-			log.Printf("[tpl] failed to access array element #%s", s)
-			return nil, nil
-		}
-		if n < 0 || int(n) >= len(o) {
+		n, ok := seqIndex(s, len(o))
+		if !ok {
 			return nil, nil
 		}
 		return o[n], nil
+	case string:
+		return stringIndex(o, s), nil
+	case *bytes.Buffer:
+		return stringIndex(o.String(), s), nil
 	case json.RawMessage:
 		// parse at json object
 		var sub interface{}
@@ -82,3 +75,175 @@ func ResolveValueIndex(ctx context.Context, v any, s string) (any, error) {
 		return nil, fmt.Errorf("unhandled type: %T", v)
 	}
 }
+
+// hasIndex returns true if index s exists in v, even if its value is nil.
+// It is used in strict mode to tell missing indexes from null values. Types
+// that cannot be checked are assumed to contain the index.
+func hasIndex(ctx context.Context, v any, s string) bool {
+	switch o := v.(type) {
+	case ArrayAccessGet, ArrayAccessGetAny:
+		return true
+	case map[string]any:
+		_, ok := o[s]
+		return ok
+	case map[string]Value:
+		_, ok := o[s]
+		return ok
+	case map[string]json.RawMessage:
+		_, ok := o[s]
+		return ok
+	case url.Values:
+		_, ok := o[s]
+		return ok
+	case Values:
+		_, ok := seqIndex(s, len(o))
+		return ok
+	case []any:
+		_, ok := seqIndex(s, len(o))
+		return ok
+	case []string:
+		_, ok := seqIndex(s, len(o))
+		return ok
+	case string:
+		return stringIndex(o, s) != nil
+	case *bytes.Buffer:
+		return stringIndex(o.String(), s) != nil
+	case json.RawMessage:
+		var sub any
+		if err := json.Unmarshal(o, &sub); err != nil {
+			return true
+		}
+		return hasIndex(ctx, sub, s)
+	case interface{ RawJSONBytes() []byte }:
+		var sub any
+		if err := json.Unmarshal(o.RawJSONBytes(), &sub); err != nil {
+			return true
+		}
+		return hasIndex(ctx, sub, s)
+	case ValueReader:
+		val, err := o.ReadValue(ctx)
+		if err != nil {
+			return true
+		}
+		return hasIndex(ctx, val, s)
+	case nil:
+		return false
+	default:
+		return true
+	}
+}
+
+// seqIndex converts s to a position in a sequence of length l. Negative
+// values count from the end, so -1 is the last element.
+func seqIndex(s string, l int) (int, bool) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		log.Printf("[tpl] failed to access array element #%s", s)
+		return 0, false
+	}
+	if n < 0 {
+		n += int64(l)
+	}
+	if n < 0 || n >= int64(l) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// stringIndex returns the character at position s of str, or nil
+func stringIndex(str, s string) any {
+	r := []rune(str)
+	n, ok := seqIndex(s, len(r))
+	if !ok {
+		return nil
+	}
+	return string(r[n])
+}
+
+// sliceBounds converts the bounds of a [from:to] slice to positions in a
+// sequence of length l. Empty bounds are the start and end of the sequence,
+// negative values count from the end and out of range values are clamped.
+func sliceBounds(from, to string, l int) (int, int, error) {
+	bound := func(s string, def int) (int, error) {
+		if s == "" {
+			return def, nil
+		}
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid slice bound %q", s)
+		}
+		if n < 0 {
+			n += int64(l)
+		}
+		return int(min(max(n, 0), int64(l))), nil
+	}
+	a, err := bound(from, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := bound(to, l)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b < a {
+		b = a
+	}
+	return a, b, nil
+}
+
+// ResolveValueSlice returns the elements of v between from and to, as used
+// by the _X[from:to] syntax. Either bound can be empty. Strings are sliced
+// by characters.
+func ResolveValueSlice(ctx context.Context, v any, from, to string) (any, error) {
+	switch o := v.(type) {
+	case Values:
+		a, b, err := sliceBounds(from, to, len(o))
+		if err != nil {
+			return nil, err
+		}
+		return o[a:b], nil
+	case []any:
+		a, b, err := sliceBounds(from, to, len(o))
+		if err != nil {
+			return nil, err
+		}
+		return o[a:b], nil
+	case []string:
+		a, b, err := sliceBounds(from, to, len(o))
+		if err != nil {
+			return nil, err
+		}
+		return o[a:b], nil
+	case string:
+		r := []rune(o)
+		a, b, err := sliceBounds(from, to, len(r))
+		if err != nil {
+			return nil, err
+		}
+		return string(r[a:b]), nil
+	case *bytes.Buffer:
+		return ResolveValueSlice(ctx, o.String(), from, to)
+	case json.RawMessage:
+		var sub any
+		if err := json.Unmarshal(o, &sub); err != nil {
+			return nil, fmt.Errorf("failed to parse json: %s", err)
+		}
+		return ResolveValueSlice(ctx, sub, from, to)
+	case interface{ RawJSONBytes() []byte }:
+		var sub any
+		if err := json.Unmarshal(o.RawJSONBytes(), &sub); err != nil {
+			return nil, fmt.Errorf("failed to parse json: %s", err)
+		}
+		return ResolveValueSlice(ctx, sub, from, to)
+	case ValueReader:
+		val, err := o.ReadValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ResolveValueSlice(ctx, val, from, to)
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unhandled type: %T", v)
+	}
+}
@@ -3,6 +3,7 @@ package tpl_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/KarpelesLab/tpl"
@@ -134,9 +135,16 @@ func TestResolveValueIndexEdgeCases(t *testing.T) {
 			false,
 		},
 		{
-			"slice_out_of_bounds_negative",
+			"slice_negative",
 			[]any{"a", "b", "c"},
 			"-1",
+			"c",
+			false,
+		},
+		{
+			"slice_out_of_bounds_negative",
+			[]any{"a", "b", "c"},
+			"-4",
 			nil,
 			false,
 		},
@@ -161,6 +169,27 @@ func TestResolveValueIndexEdgeCases(t *testing.T) {
 			nil,
 			false,
 		},
+		{
+			"string_rune",
+			"héllo",
+			"1",
+			"é",
+			false,
+		},
+		{
+			"string_negative",
+			"héllo",
+			"-1",
+			"o",
+			false,
+		},
+		{
+			"string_out_of_bounds",
+			"héllo",
+			"5",
+			nil,
+			false,
+		},
 		{
 			"json_invalid",
 			json.RawMessage(`{invalid json}`),
@@ -264,3 +293,106 @@ func TestNestedArrayAccess(t *testing.T) {
 		t.Errorf("got %q, want %q", result, "deep value")
 	}
 }
+
+func TestResolveValueSlice(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		value    any
+		from, to string
+		expected string
+		hasError bool
+	}{
+		{"range", []any{1, 2, 3, 4}, "1", "3", "[2 3]", false},
+		{"no_start", []any{1, 2, 3, 4}, "", "2", "[1 2]", false},
+		{"no_end", []any{1, 2, 3, 4}, "2", "", "[3 4]", false},
+		{"negative", []any{1, 2, 3, 4}, "-2", "", "[3 4]", false},
+		{"negative_end", []any{1, 2, 3, 4}, "", "-1", "[1 2 3]", false},
+		{"clamped", []any{1, 2, 3, 4}, "-10", "10", "[1 2 3 4]", false},
+		{"reversed", []any{1, 2, 3, 4}, "3", "1", "[]", false},
+		{"strings", []string{"a", "b", "c"}, "1", "", "[b c]", false},
+		{"string", "héllo", "1", "3", "él", false},
+		{"string_negative", "héllo", "-3", "", "llo", false},
+		{"json", json.RawMessage(`[1,2,3]`), "1", "", "[2 3]", false},
+		{"nil", nil, "1", "2", "<nil>", false},
+		{"invalid_bound", []any{1, 2}, "x", "", "", true},
+		{"unhandled_type", 42, "0", "1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tpl.ResolveValueSlice(ctx, tt.value, tt.from, tt.to)
+			if tt.hasError {
+				if err == nil {
+					t.Errorf("expected error, got nil (result: %v)", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveValueSlice failed: %v", err)
+			}
+			if got := fmt.Sprint(result); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	values := tpl.Values{tpl.NewValue("a"), tpl.NewValue("b")}
+	result, err := tpl.ResolveValueSlice(ctx, values, "-1", "")
+	if err != nil {
+		t.Fatalf("ResolveValueSlice failed: %v", err)
+	}
+	if v, ok := result.(tpl.Values); !ok || len(v) != 1 || v[0] != values[1] {
+		t.Errorf("got %v, want [b]", result)
+	}
+}
+
+func TestSliceSyntax(t *testing.T) {
+	vars := map[string]any{
+		"_a": []any{1, 2, 3, 4, 5},
+		"_s": "héllo",
+		"_m": map[string]any{"list": []any{map[string]any{"n": "A"}, map[string]any{"n": "B"}}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"last", `{{_a[-1]}}`, "5"},
+		{"negative_expression", `{{_a[-(1+1)]}}`, "4"},
+		{"range", `{{_a[1:3]|json()}}`, "[2,3]"},
+		{"no_start", `{{_a[:2]|json()}}`, "[1,2]"},
+		{"no_end", `{{_a[-2:]|json()}}`, "[4,5]"},
+		{"expression_bounds", `{{_a[(1+1):(2*2)]|json()}}`, "[3,4]"},
+		{"string_index", `{{_s[1]}}`, "é"},
+		{"string_slice", `{{_s[1:3]}}`, "él"},
+		{"path_after_index", `{{_m/list[-1]/n}}`, "B"},
+		{"path_after_slice", `{{_m/list[1:]/0/n}}`, "B"},
+		{"literal", `{{([1, 2, 3][-1])}}`, "3"},
+		{"quote", `{{"abc"[1:]}}`, "bc"},
+		{"ternary_index", `{{_a[1 ? 0 : 2]}}`, "1"},
+		{"foreach", `{{foreach {{_a[:2]}} as _v}}{{_v}};{{/foreach}}`, "1;2;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
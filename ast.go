@@ -0,0 +1,192 @@
+package tpl
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// NodeKind is the kind of a Node in a compiled template
+type NodeKind int
+
+// Node kinds. The meaning of Name and Children depends on the kind.
+const (
+	NodeInvalid  = NodeKind(internalInvalid)
+	NodeText     = NodeKind(internalText)     // Name is the text
+	NodeLink     = NodeKind(internalLink)     // variable or template, Children[0] is the name, see LinkName
+	NodeQuote    = NodeKind(internalQuote)    // string in quotes, Children[0] is the content
+	NodeValue    = NodeKind(internalValue)    // value computed at compile time, such as a literal or a static function call, see Value
+	NodeIf       = NodeKind(internalIf)       // if Children[0] then Children[1] else Children[2]
+	NodeTry      = NodeKind(internalTry)      // try Children[0] catch (Name) Children[1]
+	NodeForeach  = NodeKind(internalForeach)  // foreach Children[0] as Key => Name, Children[1] else Children[2]
+	NodeJs       = NodeKind(internalJs)       // Name
+	NodeFunc     = NodeKind(internalFunc)     // function Name, Children[0] are the arguments
+	NodeFilter   = NodeKind(internalFilter)   // filter Name, Children[0] are the arguments
+	NodeVar      = NodeKind(internalVar)      // variable Name set to Children[0]
+	NodeOperator = NodeKind(internalOperator) // operator Name, Children are the operands
+	NodeSub      = NodeKind(internalSub)      // expression Children[0]
+	NodeList     = NodeKind(internalList)     // list of values, one per child
+	NodeSet      = NodeKind(internalSet)      // variables in Filters, set for Children[0]
+	NodeIndex    = NodeKind(internalIndex)    // Children[0][Children[1]], followed by path Name if any
+	NodeBlock    = NodeKind(internalBlock)    // block Name with default content Children[0]
+	NodeExtends  = NodeKind(internalExtends)  // extends template Name, Children each contain a NodeBlock
+	NodeParent   = NodeKind(internalParent)   // parent version of the current block
+	NodeInclude  = NodeKind(internalInclude)  // include template Name, parameters in Filters
+	NodeParams   = NodeKind(internalParams)   // parameters Children[1] for template Children[0]
+	NodeSwitch   = NodeKind(internalSwitch)   // switch Children[0], cases Children[1], default Children[2]
+	NodeCase     = NodeKind(internalCase)     // case Children[0] (values): Children[1]
+	NodeBreak    = NodeKind(internalBreak)    // break, if Children[0] if any
+	NodeContinue = NodeKind(internalContinue) // continue, if Children[0] if any
+	NodeArrayLit = NodeKind(internalArrayLit) // array literal, one child per item
+	NodeMapLit   = NodeKind(internalMapLit)   // map literal, children alternate keys and values
+	NodeSlice    = NodeKind(internalSlice)    // Children[0][Children[1]:Children[2]]
+)
+
+// String returns the name of the kind, such as "Filter"
+func (k NodeKind) String() string {
+	return strings.TrimPrefix(internalType(k).String(), "internal")
+}
+
+// Node is a read-only view of a node of a compiled template
+type Node struct {
+	n *internalNode
+}
+
+// Kind returns the kind of the node
+func (n Node) Kind() NodeKind {
+	return NodeKind(n.n.typ)
+}
+
+// Name returns the name or text of the node, see NodeKind for its meaning
+func (n Node) Name() string {
+	return n.n.str
+}
+
+// Key returns the name of the key variable of a NodeForeach, if any
+func (n Node) Key() string {
+	return n.n.key
+}
+
+// Template returns the name of the template the node comes from
+func (n Node) Template() string {
+	return n.n.tpl
+}
+
+// Line returns the line of the node in the template source
+func (n Node) Line() int {
+	return n.n.line
+}
+
+// Char returns the position of the node in its line
+func (n Node) Char() int {
+	return n.n.char
+}
+
+// Children returns the lists of sub nodes, see NodeKind for their meaning
+func (n Node) Children() [][]Node {
+	if len(n.n.sub) == 0 {
+		return nil
+	}
+	res := make([][]Node, len(n.n.sub))
+	for i, a := range n.n.sub {
+		res[i] = a.nodes()
+	}
+	return res
+}
+
+// Filters returns the filters applied to the value of the node. For
+// NodeSet and NodeInclude, it also contains the variables being set.
+func (n Node) Filters() []Node {
+	return n.n.filters.nodes()
+}
+
+// Value returns the value of a NodeValue, computed when compiling
+func (n Node) Value() (any, bool) {
+	if n.n.typ != internalValue {
+		return nil, false
+	}
+	if v, ok := n.n.value.(*interfaceValue); ok {
+		return v.val, true
+	}
+	return n.n.value, true
+}
+
+// LinkName returns the name of the variable or template of a NodeLink, such
+// as "_user/name" or "header". It returns false if the name is computed when
+// running.
+func (n Node) LinkName() (string, bool) {
+	if n.n.typ != internalLink {
+		return "", false
+	}
+	return n.n.staticLink()
+}
+
+// staticLink returns the name of a link if it is known when compiling
+func (n *internalNode) staticLink() (string, bool) {
+	if len(n.sub) != 1 || len(n.sub[0]) != 1 || n.sub[0][0].typ != internalText {
+		return "", false
+	}
+	return n.sub[0][0].str, true
+}
+
+func (a internalArray) nodes() []Node {
+	if len(a) == 0 {
+		return nil
+	}
+	res := make([]Node, len(a))
+	for i, n := range a {
+		res[i] = Node{n}
+	}
+	return res
+}
+
+// SkipChildren can be returned by a Visitor to skip the children and filters
+// of the current node
+var SkipChildren = errors.New("skip children")
+
+// Visitor is called by Walk for each node. Returning an error other than
+// SkipChildren stops the walk and is returned by Walk.
+type Visitor func(n Node) error
+
+// Walk calls fn for each node of template name, in source order, parents
+// before their children and filters. It returns ErrTplNotFound if the
+// template does not exist.
+func (e *Page) Walk(name string, fn Visitor) error {
+	a, ok := e.lookup(name)
+	if !ok {
+		return ErrTplNotFound
+	}
+	return a.visit(fn)
+}
+
+func (a internalArray) visit(fn Visitor) error {
+	for _, n := range a {
+		if err := fn(Node{n}); err != nil {
+			if err == SkipChildren {
+				continue
+			}
+			return err
+		}
+		for _, sub := range n.sub {
+			if err := sub.visit(fn); err != nil {
+				return err
+			}
+		}
+		if err := n.filters.visit(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Templates returns the names of the compiled templates, sorted
+func (e *Page) Templates() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	res := make([]string, 0, len(e.compiled))
+	for k := range e.compiled {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,104 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestWalk(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = "{{header}}\n{{foreach _items as _k => _v}}{{_v/price|price()}}{{/foreach}}\n{{if _x}}{{@redirect(\"/login\")}}{{/if}}{{[1, 2]|json()}}"
+	engine.Raw.TemplateData["header"] = `{{_title|uppercase()}}`
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if got := engine.Templates(); !reflect.DeepEqual(got, []string{"header", "main"}) {
+		t.Errorf("got templates %v", got)
+	}
+
+	var found []string
+	err := engine.Walk("main", func(n tpl.Node) error {
+		switch n.Kind() {
+		case tpl.NodeFilter, tpl.NodeFunc:
+			found = append(found, fmt.Sprintf("%s %s at %s:%d:%d", n.Kind(), n.Name(), n.Template(), n.Line(), n.Char()))
+		case tpl.NodeLink:
+			if name, ok := n.LinkName(); ok {
+				found = append(found, "Link "+name)
+			}
+		case tpl.NodeForeach:
+			found = append(found, fmt.Sprintf("Foreach %s => %s", n.Key(), n.Name()))
+		case tpl.NodeValue:
+			v, _ := n.Value()
+			found = append(found, fmt.Sprintf("Value %v", v))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	expected := []string{
+		"Link header",
+		"Foreach _k => _v",
+		"Link _items",
+		"Link _v/price",
+		"Filter price at main:2:41",
+		"Link _x",
+		"Func redirect at main:3:10",
+		"Value [1 2]",
+		"Filter json at main:3:48",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("got %q\nwant %q", found, expected)
+	}
+}
+
+func TestWalkChildren(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{if _a}}yes{{else}}no{{/if}}`
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var texts []string
+	err := engine.Walk("main", func(n tpl.Node) error {
+		if n.Kind() != tpl.NodeIf {
+			return nil
+		}
+		for _, branch := range n.Children()[1:] {
+			for _, c := range branch {
+				texts = append(texts, c.Name())
+			}
+		}
+		return tpl.SkipChildren
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if !reflect.DeepEqual(texts, []string{"yes", "no"}) {
+		t.Errorf("got %q", texts)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = engine.Walk("main", func(n tpl.Node) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("got %v after %d nodes, want stop after 1", err, count)
+	}
+
+	if err := engine.Walk("nosuch", func(tpl.Node) error { return nil }); err != tpl.ErrTplNotFound {
+		t.Errorf("got %v, want ErrTplNotFound", err)
+	}
+
+	if s := tpl.NodeFilter.String(); s != "Filter" {
+		t.Errorf("got %q, want Filter", s)
+	}
+}
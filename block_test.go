@@ -0,0 +1,139 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestBlockExtends(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		render    string
+		expected  string
+	}{
+		{
+			"layout_alone",
+			map[string]string{
+				"layout": `<h1>{{block title}}Default{{/block}}</h1>`,
+			},
+			"layout",
+			"<h1>Default</h1>",
+		},
+		{
+			"override",
+			map[string]string{
+				"layout": `<h1>{{block title}}Default{{/block}}</h1><p>{{block body}}empty{{/block}}</p>`,
+				"page":   "{{extends layout}}\n{{block title}}Hello{{/block}}\nignored text",
+			},
+			"page",
+			"<h1>Hello</h1><p>empty</p>",
+		},
+		{
+			"parent",
+			map[string]string{
+				"layout": `[{{block title}}Site{{/block}}]`,
+				"page":   `{{extends layout}}{{block title}}Page - {{parent}}{{/block}}`,
+			},
+			"page",
+			"[Page - Site]",
+		},
+		{
+			"multi_level",
+			map[string]string{
+				"base":    `<{{block title}}Base{{/block}}|{{block body}}none{{/block}}>`,
+				"section": `{{extends base}}{{block title}}Section/{{parent}}{{/block}}`,
+				"page":    `{{extends section}}{{block title}}Page/{{parent}}{{/block}}{{block body}}content{{/block}}`,
+			},
+			"page",
+			"<Page/Section/Base|content>",
+		},
+		{
+			"nested_blocks",
+			map[string]string{
+				"layout": `{{block body}}({{block inner}}x{{/block}}){{/block}}`,
+				"page":   `{{extends layout}}{{block inner}}y{{/block}}`,
+			},
+			"page",
+			"(y)",
+		},
+		{
+			"variables",
+			map[string]string{
+				"layout": `{{block title}}{{/block}}`,
+				"page":   `{{extends layout}}{{block title}}{{_name}}{{/block}}`,
+			},
+			"page",
+			"World",
+		},
+		{
+			"parent_outside_block_is_include",
+			map[string]string{
+				"parent": `included`,
+				"layout": `{{parent}}`,
+			},
+			"layout",
+			"included",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = ""
+			for k, v := range tt.templates {
+				engine.Raw.TemplateData[k] = v
+			}
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_name": "World"})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, tt.render)
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBlockErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"unclosed_block", `{{block title}}x`},
+		{"close_without_block", `x{{/block}}`},
+		{"extends_twice", `{{extends a}}{{extends b}}`},
+		{"extends_in_block", `{{block a}}{{extends b}}{{/block}}`},
+		{"block_bad_name", `{{block a b}}{{/block}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error for %q", tt.template)
+			}
+		})
+	}
+}
+
+func TestExtendsMissingTemplate(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{extends nothere}}{{block a}}x{{/block}}`
+	ctx := context.Background()
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, err := engine.ParseAndReturn(ctx, "main"); err == nil {
+		t.Errorf("expected error when extending a missing template")
+	}
+}
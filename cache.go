@@ -0,0 +1,459 @@
+package tpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// cacheMagic starts data written by MarshalBinary, followed by cacheVersion.
+// cacheVersion must be changed whenever the encoding or the numbering of
+// internalType changes.
+const (
+	cacheMagic   = "TPLC"
+	cacheVersion = 3
+)
+
+// value tags in the binary cache
+const (
+	cacheNil byte = iota
+	cacheString
+	cacheFalse
+	cacheTrue
+	cacheInt
+	cacheInt64
+	cacheUint64
+	cacheFloat64
+	cacheArray  // []any
+	cacheMap    // map[string]any
+	cacheValues // Values
+	cacheBuffer // *bytes.Buffer
+	cacheBytes  // []byte
+	cacheValue  // *interfaceValue
+	cacheSafeHTML
+	cacheSafeURL
+	cacheSafeJS
+	cacheSafeCSS
+)
+
+// node flags in the binary cache
+const (
+	cacheHasValue byte = 1 << iota // value follows
+	cacheOtherTpl                  // node comes from another template, name follows
+	cacheEscaped                   // output is escaped, context follows
+	cacheOutput                    // node is written to the output
+)
+
+// MarshalBinary encodes the compiled templates of the page and its
+// properties, so they can be loaded with UnmarshalBinary without compiling
+// again. Templates must have been compiled. Values computed at compile time
+// are kept if they only contain plain data.
+func (e *Page) MarshalBinary() ([]byte, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.compiled == nil {
+		return nil, fmt.Errorf("tpl: page has not been compiled")
+	}
+
+	enc := &cacheEncoder{}
+	enc.buf = append(enc.buf, cacheMagic...)
+	enc.uint(cacheVersion)
+
+	props := make([]string, 0, len(e.Raw.PageProperties))
+	for k := range e.Raw.PageProperties {
+		props = append(props, k)
+	}
+	sort.Strings(props)
+	enc.uint(uint64(len(props)))
+	for _, k := range props {
+		enc.string(k)
+		enc.string(e.Raw.PageProperties[k])
+	}
+
+	names := make([]string, 0, len(e.compiled))
+	for k := range e.compiled {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	enc.uint(uint64(len(names)))
+	for _, k := range names {
+		enc.tpl = k
+		enc.string(k)
+		if err := enc.array(e.compiled[k]); err != nil {
+			return nil, err
+		}
+	}
+	return enc.buf, nil
+}
+
+// UnmarshalBinary loads templates encoded by MarshalBinary, replacing the
+// compiled templates and properties of the page. The sources of the templates
+// are not encoded, so Raw.TemplateData is emptied. Data encoded by a
+// different version of the format returns ErrCacheVersion.
+func (e *Page) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(cacheMagic)) {
+		return ErrInvalidCache
+	}
+	dec := &cacheDecoder{e: e, buf: data[len(cacheMagic):]}
+	if v := dec.uint(); dec.err == nil && v != cacheVersion {
+		return fmt.Errorf("%w: got version %d, expected %d", ErrCacheVersion, v, cacheVersion)
+	}
+
+	props := make(map[string]string)
+	for i := dec.count(); i > 0 && dec.err == nil; i-- {
+		k := dec.string()
+		props[k] = dec.string()
+	}
+
+	compiled := make(map[string]internalArray)
+	for i := dec.count(); i > 0 && dec.err == nil; i-- {
+		dec.tpl = dec.string()
+		compiled[dec.tpl] = dec.array()
+	}
+	if dec.err == nil && len(dec.buf) > 0 {
+		dec.err = ErrInvalidCache
+	}
+	if dec.err != nil {
+		return dec.err
+	}
+
+	e.compileMu.Lock()
+	defer e.compileMu.Unlock()
+
+	// sources of the previous templates do not match the loaded ones
+	e.Raw.TemplateData = make(map[string]string)
+	e.Raw.PageProperties = props
+	e.Version = 1
+
+	e.mu.Lock()
+	e.compiled = compiled
+	e.mu.Unlock()
+	return nil
+}
+
+type cacheEncoder struct {
+	buf []byte
+	tpl string // template being encoded
+}
+
+func (enc *cacheEncoder) uint(v uint64) {
+	enc.buf = binary.AppendUvarint(enc.buf, v)
+}
+
+func (enc *cacheEncoder) int(v int64) {
+	enc.buf = binary.AppendVarint(enc.buf, v)
+}
+
+func (enc *cacheEncoder) string(s string) {
+	enc.uint(uint64(len(s)))
+	enc.buf = append(enc.buf, s...)
+}
+
+func (enc *cacheEncoder) array(a internalArray) error {
+	enc.uint(uint64(len(a)))
+	for _, n := range a {
+		if err := enc.node(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *cacheEncoder) node(n *internalNode) error {
+	var flags byte
+	if n.value != nil {
+		flags |= cacheHasValue
+	}
+	if n.tpl != enc.tpl {
+		flags |= cacheOtherTpl
+	}
+	if n.esc.state != escNone {
+		flags |= cacheEscaped
+	}
+	if n.output {
+		flags |= cacheOutput
+	}
+	enc.uint(uint64(n.typ))
+	enc.buf = append(enc.buf, flags)
+	enc.string(n.str)
+	enc.string(n.key)
+	enc.int(int64(n.line))
+	enc.int(int64(n.char))
+	if flags&cacheOtherTpl != 0 {
+		enc.string(n.tpl)
+	}
+	if flags&cacheEscaped != 0 {
+		c := n.esc
+		enc.buf = append(enc.buf, byte(c.state), byte(c.delim), byte(c.attr), byte(c.url), byte(c.elem))
+	}
+	if flags&cacheHasValue != 0 {
+		if err := enc.value(n.value); err != nil {
+			return n.subError(err, "cannot store compiled template: %s", err)
+		}
+	}
+	enc.uint(uint64(len(n.sub)))
+	for _, sub := range n.sub {
+		if err := enc.array(sub); err != nil {
+			return err
+		}
+	}
+	return enc.array(n.filters)
+}
+
+func (enc *cacheEncoder) value(v any) error {
+	switch v := v.(type) {
+	case nil:
+		enc.buf = append(enc.buf, cacheNil)
+	case string:
+		enc.buf = append(enc.buf, cacheString)
+		enc.string(v)
+	case bool:
+		if v {
+			enc.buf = append(enc.buf, cacheTrue)
+		} else {
+			enc.buf = append(enc.buf, cacheFalse)
+		}
+	case int:
+		enc.buf = append(enc.buf, cacheInt)
+		enc.int(int64(v))
+	case int64:
+		enc.buf = append(enc.buf, cacheInt64)
+		enc.int(v)
+	case uint64:
+		enc.buf = append(enc.buf, cacheUint64)
+		enc.uint(v)
+	case float64:
+		enc.buf = append(enc.buf, cacheFloat64)
+		enc.buf = binary.LittleEndian.AppendUint64(enc.buf, math.Float64bits(v))
+	case []any:
+		enc.buf = append(enc.buf, cacheArray)
+		enc.uint(uint64(len(v)))
+		for _, x := range v {
+			if err := enc.value(x); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		enc.buf = append(enc.buf, cacheMap)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		enc.uint(uint64(len(keys)))
+		for _, k := range keys {
+			enc.string(k)
+			if err := enc.value(v[k]); err != nil {
+				return err
+			}
+		}
+	case Values:
+		enc.buf = append(enc.buf, cacheValues)
+		enc.uint(uint64(len(v)))
+		for _, x := range v {
+			if err := enc.value(x); err != nil {
+				return err
+			}
+		}
+	case *bytes.Buffer:
+		enc.buf = append(enc.buf, cacheBuffer)
+		enc.string(v.String())
+	case []byte:
+		enc.buf = append(enc.buf, cacheBytes)
+		enc.string(string(v))
+	case *interfaceValue:
+		enc.buf = append(enc.buf, cacheValue)
+		return enc.value(v.val)
+	case SafeHTML:
+		enc.buf = append(enc.buf, cacheSafeHTML)
+		enc.string(string(v))
+	case SafeURL:
+		enc.buf = append(enc.buf, cacheSafeURL)
+		enc.string(string(v))
+	case SafeJS:
+		enc.buf = append(enc.buf, cacheSafeJS)
+		enc.string(string(v))
+	case SafeCSS:
+		enc.buf = append(enc.buf, cacheSafeCSS)
+		enc.string(string(v))
+	default:
+		return fmt.Errorf("unsupported value of type %T", v)
+	}
+	return nil
+}
+
+type cacheDecoder struct {
+	e   *Page
+	buf []byte
+	tpl string // template being decoded
+	err error
+}
+
+func (dec *cacheDecoder) uint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(dec.buf)
+	if n <= 0 {
+		dec.err = ErrInvalidCache
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return v
+}
+
+func (dec *cacheDecoder) int() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(dec.buf)
+	if n <= 0 {
+		dec.err = ErrInvalidCache
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return v
+}
+
+// count reads a number of items, each using at least one byte
+func (dec *cacheDecoder) count() int {
+	v := dec.uint()
+	if v > uint64(len(dec.buf)) {
+		dec.err = ErrInvalidCache
+		return 0
+	}
+	return int(v)
+}
+
+func (dec *cacheDecoder) byte() byte {
+	if dec.err != nil {
+		return 0
+	}
+	if len(dec.buf) == 0 {
+		dec.err = ErrInvalidCache
+		return 0
+	}
+	b := dec.buf[0]
+	dec.buf = dec.buf[1:]
+	return b
+}
+
+func (dec *cacheDecoder) string() string {
+	l := dec.count()
+	if dec.err != nil {
+		return ""
+	}
+	s := string(dec.buf[:l])
+	dec.buf = dec.buf[l:]
+	return s
+}
+
+func (dec *cacheDecoder) array() internalArray {
+	l := dec.count()
+	a := make(internalArray, 0, l)
+	for i := 0; i < l && dec.err == nil; i++ {
+		a = append(a, dec.node())
+	}
+	return a
+}
+
+func (dec *cacheDecoder) node() *internalNode {
+	n := &internalNode{e: dec.e, tpl: dec.tpl}
+	n.typ = internalType(dec.uint())
+	flags := dec.byte()
+	n.output = flags&cacheOutput != 0
+	n.str = dec.string()
+	n.key = dec.string()
+	n.line = int(dec.int())
+	n.char = int(dec.int())
+	if flags&cacheOtherTpl != 0 {
+		n.tpl = dec.string()
+	}
+	if flags&cacheEscaped != 0 {
+		n.esc = escContext{escState(dec.byte()), escDelim(dec.byte()), escAttrType(dec.byte()), escURLPart(dec.byte()), escElement(dec.byte())}
+		if int(n.esc.state) >= len(escStateNames) {
+			dec.err = ErrInvalidCache
+		}
+	}
+	if flags&cacheHasValue != 0 {
+		v, _ := dec.value().(Value)
+		n.value = v
+	}
+	if l := dec.count(); l > 0 {
+		n.sub = make([]internalArray, l)
+		for i := range n.sub {
+			n.sub[i] = dec.array()
+		}
+	}
+	if f := dec.array(); len(f) > 0 {
+		n.filters = f
+	}
+	return n
+}
+
+func (dec *cacheDecoder) value() any {
+	switch dec.byte() {
+	case cacheNil:
+		return nil
+	case cacheString:
+		return dec.string()
+	case cacheFalse:
+		return false
+	case cacheTrue:
+		return true
+	case cacheInt:
+		return int(dec.int())
+	case cacheInt64:
+		return dec.int()
+	case cacheUint64:
+		return dec.uint()
+	case cacheFloat64:
+		if len(dec.buf) < 8 {
+			dec.err = ErrInvalidCache
+			return nil
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(dec.buf))
+		dec.buf = dec.buf[8:]
+		return v
+	case cacheArray:
+		res := make([]any, dec.count())
+		for i := range res {
+			res[i] = dec.value()
+		}
+		return res
+	case cacheMap:
+		l := dec.count()
+		res := make(map[string]any, l)
+		for i := 0; i < l && dec.err == nil; i++ {
+			k := dec.string()
+			res[k] = dec.value()
+		}
+		return res
+	case cacheValues:
+		res := make(Values, dec.count())
+		for i := range res {
+			res[i] = NewValue(dec.value())
+		}
+		return res
+	case cacheBuffer:
+		return bytes.NewBufferString(dec.string())
+	case cacheBytes:
+		return []byte(dec.string())
+	case cacheValue:
+		return &interfaceValue{dec.value()}
+	case cacheSafeHTML:
+		return SafeHTML(dec.string())
+	case cacheSafeURL:
+		return SafeURL(dec.string())
+	case cacheSafeJS:
+		return SafeJS(dec.string())
+	case cacheSafeCSS:
+		return SafeCSS(dec.string())
+	default:
+		dec.err = ErrInvalidCache
+		return nil
+	}
+}
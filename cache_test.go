@@ -0,0 +1,149 @@
+package tpl_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestBinaryCache(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_name": "World",
+		"_list": []any{"a", "b", "c"},
+	})
+
+	templates := map[string]string{
+		"main":    `Hello {{_name|uppercase()}}! {{include card _TITLE="x"}}{{layout}}`,
+		"card":    `{{params _TITLE _SIZE=2}}[{{_TITLE}}:{{_SIZE}}]`,
+		"base":    `<{{block body}}base{{/block}}>`,
+		"layout":  `{{extends base}}{{block body}}{{parent}}+child{{/block}}`,
+		"values":  `{{@seq(1, 3)|json()}} {{@string("abc")}} {{[1, 2.5, "x", -4]|json()}} {{{"a": {"b": [1]}}|json()}}`,
+		"control": `{{foreach _list as _k => _v}}{{if _k > 0}},{{/if}}{{_k}}={{_v}}{{/foreach}} {{switch 2}}{{case 1}}one{{case 2}}two{{/switch}}`,
+		"expr":    `{{(1 + 2 * 3)}} {{(_list[-1] .. _list[0:2]|json())}} {{("x" ?? "y")}}`,
+	}
+
+	engine := tpl.New()
+	engine.Raw.PageProperties["Charset"] = "ISO-8859-1"
+	for k, v := range templates {
+		engine.Raw.TemplateData[k] = v
+	}
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	data, err := engine.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	again, err := engine.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("MarshalBinary output is not deterministic")
+	}
+
+	loaded := &tpl.Page{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if len(loaded.Raw.TemplateData) != 0 {
+		t.Errorf("got template sources %v after loading", loaded.Raw.TemplateData)
+	}
+	if loaded.GetMime() != engine.GetMime() {
+		t.Errorf("got mime %q, want %q", loaded.GetMime(), engine.GetMime())
+	}
+
+	// card requires parameters and is only rendered through main
+	for _, name := range []string{"main", "layout", "values", "control", "expr"} {
+		expected, err := engine.ParseAndReturn(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: ParseAndReturn failed: %v", name, err)
+		}
+		result, err := loaded.ParseAndReturn(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: ParseAndReturn on loaded page failed: %v", name, err)
+		}
+		if result != expected {
+			t.Errorf("%s: got %q, want %q", name, result, expected)
+		}
+	}
+
+	// sources of the previous templates are dropped
+	other := tpl.New()
+	other.Raw.TemplateData["main"] = "other"
+	if err := other.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if len(other.Raw.TemplateData) != 0 {
+		t.Errorf("got template sources %v after loading", other.Raw.TemplateData)
+	}
+}
+
+func TestBinaryCachePositions(t *testing.T) {
+	ctx := context.Background()
+	engine := tpl.New()
+	engine.Strict = true
+	engine.Raw.TemplateData["main"] = "line 1\n  {{_missing}}"
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	data, err := engine.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	loaded := &tpl.Page{Strict: true}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	_, err = loaded.ParseAndReturn(ctx, "main")
+	var e *tpl.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *tpl.Error, got %v", err)
+	}
+	if e.Template != "main" || e.Line != 2 || e.Char != 3 {
+		t.Errorf("got error at %s %d:%d, want main 2:3", e.Template, e.Line, e.Char)
+	}
+}
+
+func TestBinaryCacheErrors(t *testing.T) {
+	engine := tpl.New()
+	if _, err := engine.MarshalBinary(); err == nil {
+		t.Errorf("expected error for page that was not compiled")
+	}
+
+	engine.Raw.TemplateData["main"] = `{{@seq(1, 2)|json()}}{{_x|uppercase()}}`
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	data, err := engine.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	p := &tpl.Page{}
+	if err := p.UnmarshalBinary([]byte("nope")); !errors.Is(err, tpl.ErrInvalidCache) {
+		t.Errorf("got %v, want ErrInvalidCache", err)
+	}
+
+	// the version follows the 4 bytes magic
+	old := append([]byte{}, data...)
+	old[4] = 0
+	if err := p.UnmarshalBinary(old); !errors.Is(err, tpl.ErrCacheVersion) {
+		t.Errorf("got %v, want ErrCacheVersion", err)
+	}
+
+	// truncated data must fail cleanly
+	for i := 5; i < len(data); i++ {
+		if err := p.UnmarshalBinary(data[:i]); !errors.Is(err, tpl.ErrInvalidCache) {
+			t.Fatalf("truncated at %d: got %v, want ErrInvalidCache", i, err)
+		}
+	}
+	if p.HasTpl("main") {
+		t.Errorf("failed UnmarshalBinary changed the page")
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
@@ -148,9 +149,16 @@ func compileTemplates(ctx context.Context, dir string) []error {
 		return nil // No templates to compile
 	}
 
-	// Compile all templates
+	// Compile all templates, reporting each error found
 	if err := engine.Compile(ctx); err != nil {
-		errs = append(errs, err)
+		var list tpl.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				errs = append(errs, e)
+			}
+		} else {
+			errs = append(errs, err)
+		}
 	}
 
 	return errs
@@ -0,0 +1,171 @@
+// Package main provides a CLI tool to compile TPL templates to Go source.
+// It loads a template directory (with _properties.json and .tpl files, as
+// used by RawData.FromDir), compiles it and writes a Go file containing the
+// compiled templates, so they ship in the binary and are not parsed at run
+// time. A test file comparing the output of each template with the output of
+// the interpreter is also written.
+//
+// Values computed at compile time, such as @phpversion() or @uname(), are the
+// ones of the machine running tplgen.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <directory> [<directory>...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Compile TPL templates to a Go source file.\n")
+		fmt.Fprintf(os.Stderr, "Directories are loaded in order, as with RawData.FromDir.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+	}
+
+	output := flag.String("o", "templates.go", "output file, the test file is written next to it")
+	pkg := flag.String("pkg", "main", "package name of the generated file")
+	fnc := flag.String("func", "NewPage", "name of the generated function returning the page")
+	withTest := flag.Bool("test", true, "also generate a test comparing with the interpreter")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	g := &generator{pkg: *pkg, fnc: *fnc, dirs: flag.Args()}
+	if err := g.generate(*output, *withTest); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generator writes the Go source of compiled templates
+type generator struct {
+	pkg  string
+	fnc  string
+	dirs []string
+}
+
+// generate compiles the templates of g.dirs and writes the Go file to
+// output, and the test file next to it if withTest is true
+func (g *generator) generate(output string, withTest bool) error {
+	engine := tpl.New()
+	if err := engine.Raw.FromDir(g.dirs...); err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
+	}
+	if err := engine.Compile(context.Background()); err != nil {
+		return fmt.Errorf("failed to compile templates:\n%w", err)
+	}
+	data, err := engine.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode templates: %w", err)
+	}
+
+	if err := g.write(output, g.source(data)); err != nil {
+		return err
+	}
+	if withTest {
+		testFile := strings.TrimSuffix(output, ".go") + "_test.go"
+		return g.write(testFile, g.test(&engine.Raw))
+	}
+	return nil
+}
+
+// header returns the comment and package clause of generated files
+func (g *generator) header() string {
+	return fmt.Sprintf("// Code generated by tplgen from %s; DO NOT EDIT.\n\npackage %s\n\n", strings.Join(g.dirs, ", "), g.pkg)
+}
+
+// varName returns the name of the variable holding the data for g.fnc
+func (g *generator) varName() string {
+	return "tplgen" + g.fnc
+}
+
+// source returns the Go file loading the compiled templates in data
+func (g *generator) source(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(g.header())
+	buf.WriteString("import \"github.com/KarpelesLab/tpl\"\n\n")
+	fmt.Fprintf(buf, "// %s contains the compiled templates, see tpl.Page.MarshalBinary\n", g.varName())
+	fmt.Fprintf(buf, "const %s = %s\n\n", g.varName(), strconv.Quote(string(data)))
+	fmt.Fprintf(buf, "// %s returns a page containing the compiled templates of %s\n", g.fnc, strings.Join(g.dirs, ", "))
+	fmt.Fprintf(buf, "func %s() (*tpl.Page, error) {\n", g.fnc)
+	buf.WriteString("\te := &tpl.Page{}\n")
+	fmt.Fprintf(buf, "\tif err := e.UnmarshalBinary([]byte(%s)); err != nil {\n", g.varName())
+	buf.WriteString("\t\treturn nil, err\n\t}\n\treturn e, nil\n}\n")
+	return buf.Bytes()
+}
+
+// test returns a Go test file rendering each template with the generated
+// page and with the interpreter, and comparing the results
+func (g *generator) test(raw *tpl.RawData) []byte {
+	names := make([]string, 0, len(raw.TemplateData))
+	for k := range raw.TemplateData {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(g.header())
+	buf.WriteString("import (\n\t\"context\"\n\t\"fmt\"\n\t\"testing\"\n\n\t\"github.com/KarpelesLab/tpl\"\n)\n\n")
+	fmt.Fprintf(buf, "func Test%sGenerated(t *testing.T) {\n", g.fnc)
+	buf.WriteString("\tctx := context.Background()\n")
+	fmt.Fprintf(buf, "\tgenerated, err := %s()\n", g.fnc)
+	fmt.Fprintf(buf, "\tif err != nil {\n\t\tt.Fatalf(\"%s failed: %%v\", err)\n\t}\n\n", g.fnc)
+
+	buf.WriteString("\tinterpreted := tpl.New()\n")
+	props := make([]string, 0, len(raw.PageProperties))
+	for k := range raw.PageProperties {
+		props = append(props, k)
+	}
+	sort.Strings(props)
+	for _, k := range props {
+		fmt.Fprintf(buf, "\tinterpreted.Raw.PageProperties[%q] = %q\n", k, raw.PageProperties[k])
+	}
+	for _, k := range names {
+		fmt.Fprintf(buf, "\tinterpreted.Raw.TemplateData[%q] = %s\n", k, strconv.Quote(raw.TemplateData[k]))
+	}
+	buf.WriteString("\tif err := interpreted.Compile(ctx); err != nil {\n\t\tt.Fatalf(\"Compile failed: %v\", err)\n\t}\n\n")
+	buf.WriteString("\tif generated.GetMime() != interpreted.GetMime() {\n")
+	buf.WriteString("\t\tt.Errorf(\"got mime %q, want %q\", generated.GetMime(), interpreted.GetMime())\n\t}\n\n")
+
+	// templates requiring parameters fail the same way on both sides
+	buf.WriteString("\tfor _, name := range []string{\n")
+	for _, k := range names {
+		fmt.Fprintf(buf, "\t\t%q,\n", k)
+	}
+	buf.WriteString("\t} {\n")
+	buf.WriteString("\t\tt.Run(name, func(t *testing.T) {\n")
+	buf.WriteString("\t\t\tgot, gotErr := generated.ParseAndReturn(ctx, name)\n")
+	buf.WriteString("\t\t\twant, wantErr := interpreted.ParseAndReturn(ctx, name)\n")
+	buf.WriteString("\t\t\tif got != want {\n\t\t\t\tt.Errorf(\"got %q, want %q\", got, want)\n\t\t\t}\n")
+	buf.WriteString("\t\t\tif fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {\n\t\t\t\tt.Errorf(\"got error %v, want %v\", gotErr, wantErr)\n\t\t\t}\n")
+	buf.WriteString("\t\t})\n\t}\n}\n")
+	return buf.Bytes()
+}
+
+// write formats src and writes it to path
+func (g *generator) write(path string, src []byte) error {
+	res, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("failed to format %s: %w", path, err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, res, 0o644)
+}
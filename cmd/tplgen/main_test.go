@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test on the generated package")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	// the generated package must be in this module to import tpl
+	dir, err := os.MkdirTemp("testdata", "gen")
+	if err != nil {
+		t.Fatalf("MkdirTemp failed: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	g := &generator{pkg: "site", fnc: "NewSitePage", dirs: []string{"testdata/site"}}
+	if err := g.generate(filepath.Join(dir, "site.go"), true); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+
+	// output of the interpreter, the generated test must match it byte for byte
+	engine := tpl.New()
+	if err := engine.Raw.FromDir("testdata/site"); err != nil {
+		t.Fatalf("FromDir failed: %v", err)
+	}
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	expected := map[string]string{
+		"main":   `<html><head><title>Home - Site</title></head><body><div class="card">FIRST (2)</div>1,2,3. 7 [1,2,3,4] ab [2,1,3] <b></body></html>`,
+		"values": `7 [1,2,3,4] ab [2,1,3] <b>`,
+	}
+	for name, want := range expected {
+		if got, err := engine.ParseAndReturn(context.Background(), name); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	cmd := exec.Command(goBin, "test", "-v", "-count=1", "./"+filepath.ToSlash(dir))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go test on generated package failed: %v\n%s", err, out)
+	}
+	for _, name := range []string{"base", "card", "main", "values"} {
+		if !strings.Contains(string(out), "--- PASS: TestNewSitePageGenerated/"+name+" ") {
+			t.Errorf("generated test did not check template %s:\n%s", name, out)
+		}
+	}
+}
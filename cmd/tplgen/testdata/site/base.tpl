@@ -0,0 +1 @@
+<html><head><title>{{block title}}Site{{/block}}</title></head><body>{{block body}}{{/block}}</body></html>
\ No newline at end of file
@@ -0,0 +1 @@
+{{params _TITLE _SIZE=1}}<div class="card">{{_TITLE|uppercase()}} ({{_SIZE}})</div>
\ No newline at end of file
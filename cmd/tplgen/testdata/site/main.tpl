@@ -0,0 +1 @@
+{{extends base}}{{block title}}Home - {{parent}}{{/block}}{{block body}}{{include card _TITLE="First" _SIZE=2}}{{foreach {{@seq(1, 3)}} as _i}}{{_i}}{{if {{_i_last}}}}.{{else}},{{/if}}{{/foreach}} {{values}}{{/block}}
\ No newline at end of file
@@ -0,0 +1 @@
+{{(1 + 2 * 3)}} {{@seq(1, 4)|json()}} {{("a" .. "b")}} {{[3, 1, 2]|reverse()|json()}} {{@string("<b>")}}
\ No newline at end of file
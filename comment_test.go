@@ -0,0 +1,79 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"simple", "a{{* comment *}}b", "ab"},
+		{"empty", "a{{**}}b", "ab"},
+		{"multiline", "a{{* line 1\nline 2\n*}}b", "ab"},
+		{"with_tags", "a{{* {{_x}} {{if 1}} }} *}}b", "ab"},
+		{"around_vars", "{{_x}}{{* note *}}{{_x}}", "XX"},
+		{"in_block", "{{if 1}}{{* note *}}yes{{/if}}", "yes"},
+		{"escaped", "\\{{* not a comment *}}", "{{* not a comment *}}"},
+		{"in_literal", "{{literal}}{{* kept *}}{{/literal}}", "{{* kept *}}"},
+		{"star_inside", "a{{* 2 * 3 *}}b", "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_x": "X"})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommentErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		line     int
+		char     int
+	}{
+		{"unterminated", "abc\n  {{* never closed", 2, 3},
+		{"position_after_comment", "{{* one\ntwo *}} {{/if}}", 2, 9},
+		{"position_same_line", "{{* x *}}{{/if}}", 1, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			err := engine.Compile(context.Background())
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %T", err)
+			}
+			if e.Line != tt.line || e.Char != tt.char {
+				t.Errorf("got error at %d:%d, want %d:%d (%s)", e.Line, e.Char, tt.line, tt.char, e)
+			}
+		})
+	}
+}
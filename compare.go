@@ -8,6 +8,14 @@ import (
 // TODO use reflection and implement better comparision?
 
 func CompareValues(ctx context.Context, o1, o2 interface{}) (bool, error) {
+	// trusted content compares as strings
+	if s, ok := safeString(o1); ok {
+		o1 = s
+	}
+	if s, ok := safeString(o2); ok {
+		o2 = s
+	}
+
 	// make sure o1 is never null
 	if o1 == nil {
 		if o2 == nil {
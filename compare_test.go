@@ -24,6 +24,10 @@ func TestCompare(t *testing.T) {
 		{"3.140", 3.14, true},
 		{language.MustParse("en-US"), "en-US", true},
 		{language.MustParse("ja-JP"), "ja-JP", true},
+		{tpl.SafeHTML("<b>"), "<b>", true},
+		{"a", tpl.SafeURL("a"), true},
+		{tpl.SafeJS("1"), 1, true},
+		{tpl.SafeCSS("a"), tpl.SafeHTML("b"), false},
 	}
 
 	ctx := context.Background()
@@ -5,8 +5,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 type fragment struct {
@@ -16,6 +19,7 @@ type fragment struct {
 	data       fragments
 	linkextra  fragments
 	ctx        *step1_context
+	quote      byte // closing character for quoted strings
 }
 
 type fragments []*fragment
@@ -34,14 +38,38 @@ func (f *fragment) newNode() *internalNode {
 }
 
 type step1_context struct {
-	curLine, curChar     int
-	startLine, startChar int
-	stack                map[int]*fragment
-	level                int
-	tmpStr               bytes.Buffer
-	cLast, c, cNext      byte
-	tpl                  string
-	e                    *Page
+	curLine, curChar   int
+	stack              map[int]*fragment
+	level              int
+	tmpStr             bytes.Buffer
+	keep               int // length of tmpStr that whitespace trimming must not touch
+	trim               step1_trim
+	tagStart           int // position in data of the last top level {{
+	textLine, textChar int // position of the first character of tmpStr
+	cLast, c, cNext    byte
+	tpl                string
+	e                  *Page
+}
+
+type step1_trim int
+
+const (
+	trimNone step1_trim = iota
+	trimAll             // skip all whitespace, following -}}
+	trimLine            // skip whitespace up to and including the end of line
+)
+
+// blockTags lists the tags that have whitespace-only lines around them
+// removed when Page.TrimBlocks is enabled
+var blockTags = map[string]bool{
+	"if": true, "elseif": true, "else": true, "/if": true,
+	"foreach": true, "/foreach": true,
+	"set": true, "/set": true,
+	"try": true, "catch": true, "/try": true,
+	"switch": true, "case": true, "default": true, "/switch": true,
+	"break": true, "continue": true,
+	"block": true, "/block": true,
+	"extends": true, "params": true,
 }
 
 func (ctx *step1_context) newFragment(t string) *fragment {
@@ -49,10 +77,8 @@ func (ctx *step1_context) newFragment(t string) *fragment {
 	f.ftyp = t
 	f.ctx = ctx
 	f.data = make(fragments, 0)
-	f.line = ctx.startLine
-	f.char = ctx.startChar
-	ctx.startLine = ctx.curLine
-	ctx.startChar = ctx.curChar
+	f.line = ctx.curLine
+	f.char = ctx.curChar
 	if ctx.level >= 0 && t != "|" {
 		ctx.stack[ctx.level].data = append(ctx.stack[ctx.level].data, f)
 	} else if ctx.level >= 0 && t == "|" {
@@ -68,54 +94,181 @@ func (ctx *step1_context) newTextFragment(txt string) *fragment {
 	f.ftyp = "text"
 	f.ctx = ctx
 	f.text = txt
-	f.line = ctx.startLine
-	f.char = ctx.startChar
-	ctx.startLine = ctx.curLine
-	ctx.startChar = ctx.curChar
+	f.line = ctx.textLine
+	f.char = ctx.textChar
 	return f
 }
 
 // Compile processes all raw templates and builds the internal representation.
-// It returns an error if any template fails to compile.
+// Compilation continues after errors where possible, and all errors found are
+// returned as an ErrorList. On error, previously compiled templates are kept.
 func (e *Page) Compile(ctx context.Context) error {
 	// Check for context cancellation
 	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	e.compileMu.Lock()
+	defer e.compileMu.Unlock()
+
+	// functions run when compiling, such as @seq(), follow the limits
+	ctx = e.withLimiter(ctx)
+
 	// Reset values
 	e.Version = 1
-	e.compiled = make(map[string]internalArray)
 
 	// Verify the template data is valid
 	if !e.Raw.IsValid() {
 		return errors.New("template is not valid (is main template missing?)")
 	}
 
-	// For each raw template, compile
+	// For each raw template, compile. Errors are collected so all of them
+	// can be reported at once.
+	var errs ErrorList
+	start, err := e.outputContext()
+	if err != nil {
+		errs.add("", err)
+		return errs.err()
+	}
+	compiled := make(map[string]internalArray)
 	for tpl, data := range e.Raw.TemplateData {
 		// Check for context cancellation between compiling templates
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if err := e.compileTpl_step1(ctx, tpl, data); err != nil {
-			// Add context to the error if it's not already our custom error type
-			if _, ok := err.(*Error); !ok {
-				err = &Error{
-					Message:  err.Error(),
-					Template: tpl,
-					Parent:   err,
-				}
-			}
-			return err
+		a, err := e.compileTpl_step1(ctx, tpl, data)
+		if err == nil {
+			_, err = a.markOutput(start)
+		}
+		if err != nil {
+			errs.add(tpl, err)
+			continue
+		}
+		compiled[tpl] = a
+	}
+
+	errs.add("", e.checkIncludes(compiled, e.Raw.TemplateData))
+	if e.Strict {
+		errs.add("", e.checkStrict(ctx, compiled, e.Raw.TemplateData))
+	}
+	if e.Policy != nil {
+		errs.add("", e.checkPolicy(compiled))
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.compiled = compiled
+	e.mu.Unlock()
+	return nil
+}
+
+// CompileTemplate compiles source as template name, replacing any template
+// of the same name. It is safe to call while other goroutines run templates
+// of the page, which see either the previous or the new version. If source
+// fails to compile, the previous version is kept and errors are returned as
+// an ErrorList.
+func (e *Page) CompileTemplate(ctx context.Context, name, source string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.compileMu.Lock()
+	defer e.compileMu.Unlock()
+	return e.updateTemplates(ctx, map[string]string{name: source}, nil)
+}
+
+// updateTemplates compiles sources and removes templates removed as a single
+// change, checking the resulting set of templates once so templates in the
+// same change can refer to each other. If any template fails, nothing is
+// changed. compileMu must be held.
+func (e *Page) updateTemplates(ctx context.Context, sources map[string]string, removed []string) error {
+	ctx = e.withLimiter(ctx)
+
+	var errs ErrorList
+	start, err := e.outputContext()
+	if err != nil {
+		errs.add("", err)
+		return errs.err()
+	}
+
+	e.mu.RLock()
+	compiled := make(map[string]internalArray, len(e.compiled)+len(sources))
+	for k, v := range e.compiled {
+		compiled[k] = v
+	}
+	e.mu.RUnlock()
+	for _, name := range removed {
+		delete(compiled, name)
+	}
+	for name, source := range sources {
+		a, err := e.compileTpl_step1(ctx, name, source)
+		if err == nil {
+			_, err = a.markOutput(start)
+		}
+		if err != nil {
+			errs.add(name, err)
+			continue
 		}
+		compiled[name] = a
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	// sources after the change, to tell missing templates from failed ones
+	after := maps.Clone(e.Raw.TemplateData)
+	if after == nil {
+		after = make(map[string]string)
+	}
+	for _, name := range removed {
+		delete(after, name)
+	}
+	maps.Copy(after, sources)
+
+	// other templates may include the new ones, check everything
+	errs.add("", e.checkIncludes(compiled, after))
+	if e.Strict {
+		errs.add("", e.checkStrict(ctx, compiled, after))
+	}
+	if e.Policy != nil {
+		errs.add("", e.checkPolicy(compiled))
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if e.Raw.TemplateData == nil {
+		e.Raw.init()
+	}
+	for _, name := range removed {
+		delete(e.Raw.TemplateData, name)
+	}
+	for name, source := range sources {
+		e.Raw.TemplateData[name] = source
 	}
 
+	e.mu.Lock()
+	e.compiled = compiled
+	e.mu.Unlock()
 	return nil
 }
 
+// RemoveTemplate removes template name from the page. Like CompileTemplate,
+// it is safe to call while templates of the page are running. If other
+// templates include it, the template is kept and errors are returned as an
+// ErrorList.
+func (e *Page) RemoveTemplate(ctx context.Context, name string) error {
+	e.compileMu.Lock()
+	defer e.compileMu.Unlock()
+
+	return e.updateTemplates(ctx, nil, []string{name})
+}
+
 func (ctx *step1_context) flush() {
+	ctx.keep = 0
 	if ctx.tmpStr.Len() == 0 {
 		return
 	}
@@ -123,7 +276,153 @@ func (ctx *step1_context) flush() {
 	ctx.tmpStr.Reset()
 }
 
-func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
+// escape writes the character for the escape sequence at the start of data
+// (following a \\) and returns the number of bytes consumed, or 0 if this is
+// not a known escape sequence
+func (ctx *step1_context) escape(data string) int {
+	if len(data) == 0 {
+		return 0
+	}
+	switch data[0] {
+	case 'n':
+		ctx.tmpStr.WriteByte('\n')
+	case 't':
+		ctx.tmpStr.WriteByte('\t')
+	case 'r':
+		ctx.tmpStr.WriteByte('\r')
+	case '\\', '"', '\'':
+		ctx.tmpStr.WriteByte(data[0])
+	case 'x':
+		if len(data) < 3 {
+			return 0
+		}
+		v, err := strconv.ParseUint(data[1:3], 16, 8)
+		if err != nil {
+			return 0
+		}
+		ctx.tmpStr.WriteByte(byte(v))
+		return 3
+	case 'u':
+		if len(data) < 5 {
+			return 0
+		}
+		v, err := strconv.ParseUint(data[1:5], 16, 16)
+		if err != nil {
+			return 0
+		}
+		ctx.tmpStr.WriteRune(rune(v))
+		return 5
+	default:
+		return 0
+	}
+	return 1
+}
+
+// closesFilter returns true if c closes the parenthesis, bracket or map
+// literal containing the current filter
+func (ctx *step1_context) closesFilter(c byte) bool {
+	if ctx.level < 2 || ctx.stack[ctx.level].ftyp != "|" {
+		return false
+	}
+	switch ctx.stack[ctx.level-1].ftyp {
+	case "(":
+		return c == ')'
+	case "[":
+		return c == ']'
+	case "{":
+		return c == '}'
+	}
+	return false
+}
+
+// cut removes the first n bytes of the text of f, updating its position
+func (f *fragment) cut(n int) {
+	for _, c := range []byte(f.text[:n]) {
+		if c == '\n' {
+			f.line++
+			f.char = 0
+		}
+		f.char++
+	}
+	f.text = f.text[n:]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// trimRight removes trailing whitespace from the pending text, without
+// touching literal or escaped content
+func (ctx *step1_context) trimRight() {
+	b := ctx.tmpStr.Bytes()
+	l := len(b)
+	for l > ctx.keep && isSpace(b[l-1]) {
+		l--
+	}
+	ctx.tmpStr.Truncate(l)
+}
+
+// skipSpace returns true if c is whitespace that needs to be skipped because
+// of a trim marker or TrimBlocks
+func (ctx *step1_context) skipSpace(c byte) bool {
+	switch {
+	case ctx.trim == trimNone:
+		return false
+	case c == '\n':
+		ctx.curLine++
+		ctx.curChar = 0
+		if ctx.trim == trimLine {
+			ctx.trim = trimNone
+		}
+	case !isSpace(c):
+		ctx.trim = trimNone
+		return false
+	}
+	return true
+}
+
+// isBlockLine returns true if the tag f, which starts at ctx.tagStart and
+// ends at end, is a block tag alone on its line
+func (ctx *step1_context) isBlockLine(f *fragment, data string, end int) bool {
+	if len(f.data) == 0 || f.data[0].ftyp != "text" {
+		return false
+	}
+	cmd := strings.TrimSpace(f.data[0].text)
+	if pos := strings.IndexByte(cmd, ' '); pos != -1 {
+		cmd = cmd[:pos]
+	}
+	if !blockTags[strings.ToLower(cmd)] {
+		return false
+	}
+	for i := ctx.tagStart - 1; i >= 0 && data[i] != '\n'; i-- {
+		if data[i] != ' ' && data[i] != '\t' {
+			return false
+		}
+	}
+	for i := end; i < len(data) && data[i] != '\n'; i++ {
+		if data[i] != ' ' && data[i] != '\t' && data[i] != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// trimBlockLine removes the indentation preceding the block tag f
+func (ctx *step1_context) trimBlockLine(f *fragment) {
+	parent := ctx.stack[0]
+	pos := len(parent.data) - 2 // last fragment before f
+	if pos < 0 || parent.data[pos+1] != f || parent.data[pos].ftyp != "text" {
+		return
+	}
+	txt := strings.TrimRight(parent.data[pos].text, " \t")
+	if txt == "" {
+		parent.data = append(parent.data[:pos], f)
+		return
+	}
+	parent.data[pos].text = txt
+}
+
+func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) (internalArray, error) {
 	// analyze string, detect {{ and }} and append in an array where it is cut
 	var ctx step1_context
 
@@ -131,7 +430,7 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 	ctx.tpl = tpl
 	ctx.e = e
 	ctx.level = -1
-	ctx.curLine, ctx.startLine = 1, 1
+	ctx.curLine = 1
 	ctx.stack = make(map[int]*fragment)
 
 	// create root
@@ -147,6 +446,13 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 		} else {
 			ctx.cNext = 0
 		}
+		if ctx.tmpStr.Len() == 0 {
+			ctx.textLine, ctx.textChar = ctx.curLine, ctx.curChar
+		}
+
+		if ctx.skipSpace(ctx.c) {
+			continue
+		}
 
 		// based on current var...
 		switch {
@@ -155,24 +461,70 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 				// this is actually an escaped one, not to be an opening
 				ctx.tmpStr.Truncate(ctx.tmpStr.Len() - 1) // remove last char, which should be the \\
 				ctx.tmpStr.WriteString("{{")
+				ctx.keep = ctx.tmpStr.Len()
 				i++
 				ctx.curChar++
 				break
 			}
+			// is this a comment?
+			if strings.HasPrefix(data[i:], "{{*") {
+				endPos := strings.Index(data[i+3:], "*}}")
+				if endPos == -1 {
+					return nil, &Error{Message: "unterminated comment", Template: tpl, Line: ctx.curLine, Char: ctx.curChar}
+				}
+				end := i + 3 + endPos + 3
+				for _, c := range []byte(data[i+1 : end]) {
+					ctx.curChar++
+					if c == '\n' {
+						ctx.curLine++
+						ctx.curChar = 0
+					}
+				}
+				ctx.c = '}'
+				i = end - 1
+				break
+			}
 			// is this a literal fragment?
 			if strings.HasPrefix(data[i:], "{{literal}}") {
 				endPos := strings.Index(data[i:], "{{/literal}}")
 				if endPos > 0 {
 					ctx.tmpStr.WriteString(data[i+11 : i+endPos])
+					ctx.keep = ctx.tmpStr.Len()
 					i += endPos + 11
 					break
 				}
 			}
+			// "{{- " trims whitespace before the tag
+			trim := i+3 < len(data) && data[i+2] == '-' && isSpace(data[i+3])
+			if trim {
+				ctx.trimRight()
+			}
 			ctx.flush()
+			if ctx.level == 0 {
+				ctx.tagStart = i
+			}
 			ctx.newFragment("{{")
 			i++
 			ctx.curChar++
+			if trim {
+				i++
+				ctx.curChar++
+				ctx.trim = trimAll
+			}
+		case ctx.closesFilter(ctx.c): // closing (, [ or { after a filter
+			ctx.flush()
+			ctx.level -= 2
+		case ctx.c == '}' && ctx.stack[ctx.level].ftyp == "{": // closing map literal
+			ctx.flush()
+			ctx.level--
 		case ctx.c == '}' && ctx.cNext == '}' && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // closing expression
+			// " -}}" trims whitespace after the tag
+			trim := false
+			if b := ctx.tmpStr.Bytes(); len(b) >= 2 && b[len(b)-1] == '-' && isSpace(b[len(b)-2]) {
+				ctx.tmpStr.Truncate(len(b) - 1)
+				ctx.trimRight()
+				trim = true
+			}
 			ctx.flush()
 			if ctx.stack[ctx.level].ftyp == "|" {
 				ctx.level--
@@ -180,14 +532,24 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 			ctx.level--
 			i++
 			ctx.curChar++
-		case ctx.c == '\\' && ctx.cNext == '"' && ctx.stack[ctx.level].ftyp == `"`: // escaped quote
-			ctx.tmpStr.WriteByte('"')
-			i++
-			ctx.curChar++
-		case ctx.c == '"' && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // opening quote
+			if trim {
+				ctx.trim = trimAll
+			} else if ctx.level == 0 && e.TrimBlocks && ctx.isBlockLine(ctx.stack[1], data, i+1) {
+				ctx.trimBlockLine(ctx.stack[1])
+				ctx.trim = trimLine
+			}
+		case ctx.c == '\\' && ctx.stack[ctx.level].ftyp == `"`: // escape sequence
+			if n := ctx.escape(data[i+1:]); n > 0 {
+				i += n
+				ctx.curChar += n
+				ctx.c = 0 // escaped content can't be part of a {{
+			} else {
+				ctx.tmpStr.WriteByte('\\')
+			}
+		case (ctx.c == '"' || ctx.c == '\'') && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // opening quote
 			ctx.flush()
-			ctx.newFragment(`"`)
-		case ctx.c == '"' && ctx.stack[ctx.level].ftyp == `"`: // closing quote
+			ctx.newFragment(`"`).quote = ctx.c
+		case ctx.c == ctx.stack[ctx.level].quote && ctx.stack[ctx.level].ftyp == `"`: // closing quote
 			ctx.flush()
 			ctx.level--
 		case ctx.c == '(' && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // (sub)parenthesis in {{}}
@@ -196,6 +558,9 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 		case ctx.c == ')' && ctx.stack[ctx.level].ftyp == "(": // closing parenthesis
 			ctx.flush()
 			ctx.level--
+		case ctx.c == '{' && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // map literal in {{}}
+			ctx.flush()
+			ctx.newFragment("{")
 		case ctx.c == '[' && ctx.level > 0 && ctx.stack[ctx.level].ftyp != `"`: // bracket index in {{}}
 			ctx.flush()
 			ctx.newFragment("[")
@@ -224,573 +589,882 @@ func (e *Page) compileTpl_step1(rctx context.Context, tpl, data string) error {
 		for i := 0; i <= ctx.level; i++ {
 			tags = append(tags, ctx.stack[i].ftyp)
 		}
-		return ctx.stack[ctx.level].error("Badly constructed page, not all tags are properly closed: %s", tags)
+		return nil, ctx.stack[ctx.level].error("Badly constructed page, not all tags are properly closed: %s", tags)
 	}
 
 	return e.compileTpl_step2(rctx, tpl, root)
 }
 
-func (e *Page) compileTpl_step2(ctx context.Context, tpl string, root *fragment) error {
+func (e *Page) compileTpl_step2(ctx context.Context, tpl string, root *fragment) (internalArray, error) {
 	newroot, err := e.compileTpl_step2_recurse(ctx, root.data, false)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	newroot, err = newroot.compileExtends()
+	if err != nil {
+		return nil, err
+	}
+	newroot, err = newroot.compileParams()
+	if err != nil {
+		return nil, err
+	}
+	if err = newroot.checkOperators(); err != nil {
+		return nil, err
+	}
+	return newroot, nil
+}
+
+// compileExtends turns a template containing {{extends}} into a single
+// internalExtends node holding the blocks defined at the top level. Anything
+// else outside of blocks and {{params}} is ignored, as it would never be
+// displayed.
+func (a internalArray) compileExtends() (internalArray, error) {
+	var ext *internalNode
+	for _, n := range a {
+		if n.typ != internalExtends {
+			continue
+		}
+		if ext != nil {
+			return nil, n.error("extends present more than once")
+		}
+		ext = n
+	}
+	if ext == nil {
+		return a, nil
+	}
+
+	res := internalArray{}
+	for _, n := range a {
+		switch n.typ {
+		case internalBlock:
+			ext.sub = append(ext.sub, internalArray{n})
+		case internalParams:
+			res = append(res, n)
+		}
+	}
+	return append(res, ext), nil
+}
+
+// compileParams moves everything following {{params}} inside it, so the
+// parameters defaults are available to the rest of the template.
+func (a internalArray) compileParams() (internalArray, error) {
+	for i, n := range a {
+		if n.typ != internalParams {
+			continue
+		}
+		for _, next := range a[i+1:] {
+			if next.typ == internalParams {
+				return nil, next.error("params present more than once")
+			}
+		}
+		n.sub[0] = append(n.sub[0], a[i+1:]...)
+		return a[:i+1], nil
 	}
-	e.compiled[tpl] = newroot
+	return a, nil
+}
 
+// params returns the {{params}} declaration of the template, if any
+func (a internalArray) params() *internalNode {
+	for _, n := range a {
+		if n.typ == internalParams {
+			return n
+		}
+	}
 	return nil
 }
 
-func (e *Page) compileTpl_step2_recurse(ctx context.Context, fl fragments, isExpression bool) (res internalArray, err error) {
-	res = internalArray{}
+// checkIncludes verifies that each {{include}} refers to an existing template
+// and passes parameters matching the ones this template declares, if any.
+// sources are the sources of the page, see failed.
+func (e *Page) checkIncludes(compiled map[string]internalArray, sources map[string]string) error {
+	var errs ErrorList
+	for _, a := range compiled {
+		a.walk(func(n *internalNode) error {
+			if n.typ != internalInclude {
+				return nil
+			}
+			target, ok := compiled[n.str]
+			if !ok {
+				if failed(sources, n.str) {
+					return nil
+				}
+				errs.add("", n.error("include of non-existing template %s", n.str))
+				return nil
+			}
+			decl := target.params()
+			if decl == nil {
+				// template doesn't declare its parameters, accept anything
+				return nil
+			}
+			passed := make(map[string]bool)
+			for _, p := range n.filters {
+				if p.typ == internalVar {
+					passed[p.str] = true
+				}
+			}
+			declared := make(map[string]bool)
+			for _, p := range decl.sub[1] {
+				declared[p.str] = true
+				if len(p.sub) == 0 && !passed[p.str] {
+					errs.add("", n.error("include of %s is missing required parameter %s", n.str, p.str))
+				}
+			}
+			for _, p := range n.filters {
+				if p.typ == internalVar && !declared[p.str] {
+					errs.add("", n.error("include of %s with unknown parameter %s", n.str, p.str))
+				}
+			}
+			return nil
+		})
+	}
+	return errs.err()
+}
+
+// failed returns true if tpl has a source but is not compiled, as it failed
+// to compile and the errors were already reported
+func failed(sources map[string]string, tpl string) bool {
+	_, ok := sources[tpl]
+	return ok
+}
+
+// checkStrict makes sure all filters, functions and templates referenced in
+// the page exist. Functions and filters passed through the context must be
+// set in the context given to Compile.
+func (e *Page) checkStrict(ctx context.Context, compiled map[string]internalArray, sources map[string]string) error {
+	var errs ErrorList
+	for _, a := range compiled {
+		a.walk(func(n *internalNode) error {
+			switch n.typ {
+			case internalFilter:
+				if _, ok := e.filter(ctx, n.str); !ok {
+					errs.add("", n.error("call to undefined filter %s", n.str))
+				}
+			case internalFunc:
+				if _, ok := e.function(ctx, n.str); !ok {
+					errs.add("", n.error("call to undefined function @%s", n.str))
+				}
+			case internalLink:
+				// only static links can be checked
+				name, ok := n.staticLink()
+				if !ok || name == "" || name[0] == '_' || name[0] == '$' {
+					return nil
+				}
+				name = strings.ToLower(strings.SplitN(name, "/", 2)[0])
+				if _, ok := compiled[name]; !ok && !failed(sources, name) {
+					errs.add("", n.error("undefined template %s", name))
+				}
+			}
+			return nil
+		})
+	}
+	return errs.err()
+}
+
+func (e *Page) compileTpl_step2_recurse(ctx context.Context, fl fragments, isExpression bool) (internalArray, error) {
+	res, err := e.compileTpl_step2_tokens(ctx, internalArray{}, fl, isExpression)
+	if err != nil || !isExpression {
+		return res, err
+	}
+	return parseExpression(res)
+}
+
+// compileTpl_step2_tokens compiles fl and appends the result to prev. For
+// expressions, the result is a flat list of values and operators, which is
+// then given to parseExpression.
+func (e *Page) compileTpl_step2_tokens(ctx context.Context, prev internalArray, fl fragments, isExpression bool) (res internalArray, err error) {
+	res = prev
 	stack := make(map[int]*internalNode)
 	stackArray := make(map[int]*internalArray)
 	level := 0
 	cur := &res
 	stackArray[level] = &res
 
-Loop:
+	var errs ErrorList
+	failed := make(map[*internalNode]bool) // opening tags that failed to compile
+
 	for i := 0; i < len(fl); i++ {
 		cur = stackArray[level]
 		f := fl[i]
 		n := f.newNode()
 
-		switch f.ftyp {
-		case "text":
-			if isExpression {
-				txt := f.text
-				// character by character analysis
-				var val string
-				for j := 0; j < len(txt); j++ {
-					switch txt[j] {
-					case ' ', '\r', '\n', '\t':
-						// NOOP - skip whitespace
-					case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
-						val = val + string(txt[j])
-					default:
-						// check if math operators
-						var l int
-						var ok bool
-
-						if j < len(txt)-1 {
-							_, ok = math_operators[txt[j:j+2]]
-							l = 2
-						}
-						if !ok {
-							_, ok = math_operators[txt[j:j+1]]
-							l = 1
-						}
-
-						if !ok {
-							return nil, &Error{Message: fmt.Sprintf("unhandled char %c", txt[j]), Template: n.tpl, Line: n.line, Char: n.char}
-						}
-
-						// cut text at position
-						if len(val) > 0 {
-							n.typ = internalText
-							n.str = val
-							res = append(res, n)
-							val = ""
-							n = f.newNode()
-						}
-						n.typ = internalOperator
-						n.str = txt[j : j+l]
-						res = append(res, n)
-						val = ""
-						n = f.newNode()
-						if l == 2 {
-							j++
+		err = func() (err error) {
+			switch f.ftyp {
+			case "text":
+				if isExpression {
+					var call *internalNode
+					res, call, err = f.tokenize(res)
+					if err != nil {
+						return
+					}
+					n = call
+					if call != nil {
+						// function call, arguments are in the next fragment
+						if i+1 >= len(fl) || fl[i+1].ftyp != "(" {
+							found := "end of expression"
+							if i+1 < len(fl) {
+								found = fl[i+1].ftyp
+							}
+							err = call.error("expected ( after @%s, found %s", call.str, found)
+							return
 						}
+						i++
+						err = e.compileFunc(ctx, call, call.str, fl[i])
 					}
-				}
-				if len(val) > 0 {
-					n.typ = internalText
-					n.str = val
 				} else {
-					n = nil
+					// check if text is not empty
+					if f.text == "" {
+						n = nil
+					} else {
+						n.typ = internalText
+						n.str = f.text
+					}
 				}
-			} else {
-				// check if text is not empty
-				if f.text == "" {
-					n = nil
+			case `"`:
+				n.typ = internalQuote
+				n.sub = make([]internalArray, 1)
+				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, false)
+			case "(":
+				n.typ = internalSub
+				n.sub = make([]internalArray, 1)
+				if isExpression {
+					n.sub[0], err = e.compileExpression(ctx, f)
 				} else {
-					n.typ = internalText
-					n.str = f.text
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true)
 				}
-			}
-		case `"`:
-			n.typ = internalQuote
-			n.sub = make([]internalArray, 1)
-			n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, false)
-		case "(":
-			n.typ = internalSub
-			n.sub = make([]internalArray, 1)
-			n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true)
-		case "[":
-			// Bracket index - the content is evaluated and used as index key
-			n.typ = internalIndex
-			n.sub = make([]internalArray, 1)
-			n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // evaluate as expression
-		case "|":
-			if len(f.data) != 2 {
-				// should be 2 (string func name, then parenthesis args)
-				err = f.error("invalid filter call")
-				return
-			}
-			if f.data[0].ftyp != "text" {
-				err = f.error("invalid filter call")
-				return
-			}
-			n.typ = internalFilter
-			n.str = f.data[0].text
-			n.sub = make([]internalArray, 1)
-			n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[1:2], true)
-			if err != nil {
-				return
-			}
-			if n.str[0] == '_' && n.str[len(n.str)-1] == '=' {
-				// not a filter but a var assign
-				n.typ = internalVar
-				n.str = strings.ToLower(n.str[:len(n.str)-1])
-			}
-			// filter filter? shouldn't happen but who knows...
-			n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
-		case "{{":
-			// check for text
-			if f.data[0].ftyp != "text" {
-				// Check if it's a standalone quote (for direct string output)
-				// e.g., {{"hello"}} or {{"hello"|filter()}}
-				if len(f.data) == 1 && f.data[0].ftyp == `"` {
-					// Direct string output
-					n.typ = internalQuote
-					n.sub = make([]internalArray, 1)
-					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[0].data, false)
-					if err != nil {
-						return
-					}
-					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+			case "[":
+				if isExpression && (len(res) == 0 || (res[len(res)-1].typ == internalOperator && len(res[len(res)-1].sub) == 0)) {
+					// no value before, this is an array literal
+					err = e.compileArrayLit(ctx, f, n)
 					break
 				}
-				// Check if it starts with parenthesis - treat as expression
-				// e.g., {{(1+2)}} or {{(1 + 2) * 3}}
-				if f.data[0].ftyp == "(" {
-					// Parse as expression
-					n.typ = internalSub
-					n.sub = make([]internalArray, 1)
-					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // true = isExpression
-					if err != nil {
-						return
-					}
-					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+				if isExpression {
+					// index of the previous value
+					n, err = e.compileIndex(ctx, f, internalArray{res[len(res)-1]})
+					res = res[:len(res)-1]
 					break
 				}
-				// consider it a link
-				n.typ = internalLink
+				// Bracket index - the content is evaluated and used as index key
+				n.typ = internalIndex
 				n.sub = make([]internalArray, 1)
-				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, false)
-				if err != nil {
-					return
-				}
-				n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
-				break
-			}
-
-			// extract first word in text, analyze it (fallback to link if none)
-			txt := f.data[0].text
-			cmd := txt
-			if cmd[0] == '@' {
+				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // evaluate as expression
+			case "{":
+				err = e.compileMapLit(ctx, f, n)
+			case "|":
 				if len(f.data) != 2 {
 					// should be 2 (string func name, then parenthesis args)
-					err = f.error("invalid method call")
-					return
-				}
-				n.typ = internalFunc
-				n.str = cmd[1:]
-				n.sub = make([]internalArray, 1)
-				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[1:2], true)
-				if n.sub[0].isStatic() {
-					t := &interfaceValue{}
-					fnc, ok := tplFunctions[n.str]
-					if ok && fnc.CanCompile {
-						if params, err2 := n.sub[0].ToValues(ctx); err2 == nil {
-							err = fnc.Method(ctx, params, t)
-							if err != nil {
-								err = f.error("error running method %s: %s", n.str, err)
-								return
-							}
-							if err == nil {
-								// update things
-								n.typ = internalValue
-								n.str = ""
-								n.sub = nil
-								n.value = t
-							}
-						}
-					}
-				}
-				if err != nil {
-					return
-				}
-				n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
-				break
-			}
-			pos := strings.IndexByte(txt, ' ')
-			if pos != -1 {
-				cmd = txt[:pos]
-			}
-			switch strings.ToLower(cmd) {
-			case "foreach":
-				n.typ = internalForeach
-				n.sub = make([]internalArray, 2) // will add one more if has else
-				if strings.TrimSpace(txt) != "foreach" {
-					err = f.error("foreach invalid syntax")
+					err = f.error("invalid filter call")
 					return
 				}
-				if len(f.data) != 3 {
-					// wat??? we expect "foreach " [var] "as (varname)"
-					err = f.error("foreach invalid syntax")
+				if f.data[0].ftyp != "text" {
+					err = f.error("invalid filter call")
 					return
 				}
-				if f.data[2].ftyp != "text" {
-					// we expect text to contain "as _varname"
-					err = f.error("foreach invalid syntax")
-					return
-				}
-				// parse foreach variable
-				n.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{f.data[1]}, false) // condition for {{if}}
+				n.typ = internalFilter
+				n.str = f.data[0].text
+				n.sub = make([]internalArray, 1)
+				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[1:2], true)
 				if err != nil {
 					return
 				}
-				n.sub[1] = internalArray{}
-				foreachVar := strings.TrimSpace(f.data[2].text)
-				if !strings.HasPrefix(foreachVar, "as ") {
-					err = f.error("foreach invalid syntax")
-					return
-				}
-				foreachVar = strings.TrimSpace(foreachVar[2:])
-				if len(foreachVar) < 2 || !strings.HasPrefix(foreachVar, "_") {
-					err = f.error("foreach invalid syntax")
-					return
-				}
-				n.str = strings.ToLower(foreachVar)
-				level++
-				stack[level] = n
-				stackArray[level] = &n.sub[1]
-			case "/foreach":
-				if level < 1 || stack[level].typ != internalForeach {
-					err = f.error("/foreach at invalid position")
-					return
+				if n.str[0] == '_' && n.str[len(n.str)-1] == '=' {
+					// not a filter but a var assign
+					n.typ = internalVar
+					n.str = strings.ToLower(n.str[:len(n.str)-1])
 				}
-				level--
-				cur = stackArray[level]
-				n = nil
-			case "set":
-				n.typ = internalSet
-				n.sub = make([]internalArray, 1) // will add one more if has else
-				if len(txt) <= 4 {
-					if len(f.data) > 1 {
-						f.data = f.data[1:]
-					} else {
-						//hum? let's just avoid crashing
-						f.data = make(fragments, 0)
+				// filter filter? shouldn't happen but who knows...
+				n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+			case "{{":
+				// check for text
+				if f.data[0].ftyp != "text" {
+					// Check if it's a standalone quote (for direct string output)
+					// e.g., {{"hello"}} or {{"hello"|filter()}}
+					if len(f.data) == 1 && f.data[0].ftyp == `"` {
+						// Direct string output
+						n.typ = internalQuote
+						n.sub = make([]internalArray, 1)
+						n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[0].data, false)
+						if err != nil {
+							return
+						}
+						n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+						break
 					}
-				} else {
-					f.data[0].text = f.data[0].text[4:]
+					// Check if it starts with parenthesis - treat as expression
+					// e.g., {{(1+2)}} or {{(1 + 2) * 3}}
+					if f.data[0].ftyp == "(" || f.data[0].ftyp == "[" || f.data[0].ftyp == "{" || f.data[0].ftyp == `"` {
+						// Parse as expression
+						n.typ = internalSub
+						n.sub = make([]internalArray, 1)
+						n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // true = isExpression
+						if err != nil {
+							return
+						}
+						n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+						break
+					}
+					// consider it a link
+					n.typ = internalLink
+					n.sub = make([]internalArray, 1)
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, false)
+					if err != nil {
+						return
+					}
+					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+					break
 				}
 
-				// Parse variable assignments from f.data
-				// Handles both: {{set _I="value"}} (multi-fragment) and {{set _I=0}} (single-fragment)
-				for len(f.data) > 0 {
-					if f.data[0].ftyp != "text" {
-						err = f.data[0].error("invalid set instruction")
+				// extract first word in text, analyze it (fallback to link if none)
+				txt := f.data[0].text
+				cmd := txt
+				if cmd[0] == '@' {
+					if len(f.data) != 2 {
+						// should be 2 (string func name, then parenthesis args)
+						err = f.error("invalid method call")
 						return
 					}
-
-					tmp := strings.TrimSpace(f.data[0].text)
-					if tmp == "" {
-						// Skip empty text fragments
-						f.data = f.data[1:]
-						continue
+					if err = e.compileFunc(ctx, n, cmd[1:], f.data[1]); err != nil {
+						return
 					}
-
-					// Check if this text contains an = sign (indicating _VAR=value pattern)
-					eqPos := strings.Index(tmp, "=")
-					if eqPos == -1 {
-						err = f.data[0].error("invalid set instruction: missing =")
+					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+					break
+				}
+				pos := strings.IndexByte(txt, ' ')
+				if pos != -1 {
+					cmd = txt[:pos]
+				}
+				switch strings.ToLower(cmd) {
+				case "foreach":
+					n.typ = internalForeach
+					n.sub = make([]internalArray, 2) // will add one more if has else
+					// "as" is in the last text, after the value and its filters if any
+					last := &f.data
+					if len(f.linkextra) > 0 {
+						last = &f.linkextra[len(f.linkextra)-1].data
+					}
+					if len(*last) == 0 || (*last)[len(*last)-1].ftyp != "text" {
+						err = f.error("foreach invalid syntax")
 						return
 					}
-
-					varName := strings.ToLower(strings.TrimSpace(tmp[:eqPos]))
-					if len(varName) == 0 || varName[0] != '_' {
-						err = f.data[0].error("invalid set instruction: variable must start with _")
+					asFrag := (*last)[len(*last)-1]
+					padded := " " + asFrag.text
+					pos := strings.LastIndex(padded, " as ")
+					if pos == -1 {
+						err = f.error("foreach invalid syntax")
 						return
 					}
+					n.key, n.str, err = parseForeachVars(f, padded[pos+4:])
+					if err != nil {
+						return
+					}
+					if strings.TrimSpace(padded[:pos]) == "" && asFrag != f.data[0] {
+						*last = (*last)[:len(*last)-1]
+					} else {
+						asFrag.text = padded[1:pos]
+					}
 
-					valueStr := strings.TrimSpace(tmp[eqPos+1:])
-
-					nVar := f.data[0].newNode()
-					nVar.typ = internalVar
-					nVar.str = varName
-					nVar.sub = make([]internalArray, 1)
-
-					// Check if the value part is empty (meaning the value is in the next fragment)
-					if valueStr == "" && len(f.data) > 1 {
-						// Value is in next fragment (e.g., {{set _I="value"}})
-						nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[1:2], true)
+					// parse foreach value, either {{_var}}, _var or a literal
+					src := strings.TrimSpace(f.data[0].text[7:])
+					var srcFrag *fragment
+					switch {
+					case src == "" && len(f.data) == 2 && (f.data[1].ftyp == "[" || f.data[1].ftyp == "{" || f.data[1].ftyp == "("):
+						lit := f.newNode()
+						lit.typ = internalSub
+						lit.sub = make([]internalArray, 1)
+						lit.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data[1:], true)
+						if err != nil {
+							return
+						}
+						lit.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
 						if err != nil {
 							return
 						}
-						n.filters = append(n.filters, nVar)
-						f.data = f.data[2:]
-					} else if valueStr != "" {
-						// Value is inline in the text (e.g., {{set _I=0}})
-						// Create a synthetic fragment for the value and compile it
-						valFrag := f.data[0].newNode()
-						valFrag.typ = internalText
-						valFrag.str = valueStr
-
-						// Try to parse as expression (number, variable reference, etc.)
-						synthFrag := &fragment{
-							ftyp: "text",
-							text: valueStr,
-							ctx:  f.data[0].ctx,
-							line: f.data[0].line,
-							char: f.data[0].char,
+						n.sub[0] = internalArray{lit}
+					case src == "" && len(f.data) == 2 && f.data[1].ftyp == "{{":
+						srcFrag = f.data[1]
+						if len(f.linkextra) > 0 {
+							t := *srcFrag
+							t.linkextra = append(append(fragments{}, srcFrag.linkextra...), f.linkextra...)
+							srcFrag = &t
 						}
-						nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{synthFrag}, true)
+					case strings.HasPrefix(src, "_"):
+						srcFrag = &fragment{ftyp: "{{", ctx: f.ctx, line: f.line, char: f.char, linkextra: f.linkextra}
+						srcFrag.data = append(fragments{{ftyp: "text", text: src, ctx: f.ctx, line: f.line, char: f.char}}, f.data[1:]...)
+					default:
+						err = f.error("foreach invalid syntax")
+						return
+					}
+					if srcFrag != nil {
+						n.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{srcFrag}, false)
 						if err != nil {
 							return
 						}
-						n.filters = append(n.filters, nVar)
-						f.data = f.data[1:]
+					}
+					n.sub[1] = internalArray{}
+					level++
+					stack[level] = n
+					stackArray[level] = &n.sub[1]
+				case "/foreach":
+					if level < 1 || stack[level].typ != internalForeach {
+						err = f.error("/foreach at invalid position")
+						return
+					}
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "set":
+					n.typ = internalSet
+					n.sub = make([]internalArray, 1) // will add one more if has else
+					if len(txt) <= 4 {
+						if len(f.data) > 1 {
+							f.data = f.data[1:]
+						} else {
+							//hum? let's just avoid crashing
+							f.data = make(fragments, 0)
+						}
 					} else {
-						err = f.data[0].error("invalid set instruction: missing value")
+						f.data[0].cut(4)
+					}
+
+					n.filters, err = e.compileAssignments(ctx, f.data, "set")
+					if err != nil {
 						return
 					}
-				}
 
-				n.sub[0] = internalArray{}
-				level++
-				stack[level] = n
-				stackArray[level] = &n.sub[0]
-			case "/set":
-				if level < 1 || stack[level].typ != internalSet {
-					err = f.error("/set at invalid position")
-					return
-				}
-				level--
-				cur = stackArray[level]
-				n = nil
-			case "if":
-				n.typ = internalIf
-				n.sub = make([]internalArray, 2) // will add one more if has else
-				if len(txt) <= 3 {
-					if len(f.data) > 1 {
-						f.data = f.data[1:]
+					n.sub[0] = internalArray{}
+					level++
+					stack[level] = n
+					stackArray[level] = &n.sub[0]
+				case "/set":
+					if level < 1 || stack[level].typ != internalSet {
+						err = f.error("/set at invalid position")
+						return
+					}
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "if":
+					n.typ = internalIf
+					n.sub = make([]internalArray, 2) // will add one more if has else
+					if len(txt) <= 3 {
+						if len(f.data) > 1 {
+							f.data = f.data[1:]
+						} else {
+							//hum? let's just avoid crashing
+							f.data = make(fragments, 0)
+						}
 					} else {
-						//hum? let's just avoid crashing
-						f.data = make(fragments, 0)
+						f.data[0].cut(3)
+					}
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // condition for {{if}}
+					if err != nil {
+						return
+					}
+					n.sub[1] = internalArray{}
+					level++
+					stack[level] = n
+					stackArray[level] = &n.sub[1]
+				case "/if":
+					if level < 1 || stack[level].typ != internalIf {
+						err = f.error("/if at invalid position")
+						return
+					}
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "else":
+					if level < 1 || (stack[level].typ != internalIf && stack[level].typ != internalForeach) {
+						err = f.error("else at invalid position")
+						return
+					}
+					if len(stack[level].sub) > 2 {
+						err = f.error("else present more than once")
+						return
+					}
+					stack[level].sub = append(stack[level].sub, internalArray{})
+					stackArray[level] = &stack[level].sub[len(stack[level].sub)-1]
+					n = nil
+				case "elseif":
+					// create a new if in the current if's sub[2], and record it at the same level in the stack
+					if level < 1 || (stack[level].typ != internalIf) {
+						err = f.error("elseif at invalid position")
+						return
+					}
+					if len(stack[level].sub) > 2 {
+						err = f.error("elseif after else")
+						return
 					}
-				} else {
-					f.data[0].text = f.data[0].text[3:]
-				}
-				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // condition for {{if}}
-				if err != nil {
-					return
-				}
-				n.sub[1] = internalArray{}
-				level++
-				stack[level] = n
-				stackArray[level] = &n.sub[1]
-			case "/if":
-				if level < 1 || stack[level].typ != internalIf {
-					err = f.error("/if at invalid position")
-					return
-				}
-				level--
-				cur = stackArray[level]
-				n = nil
-			case "else":
-				if level < 1 || (stack[level].typ != internalIf && stack[level].typ != internalForeach) {
-					err = f.error("else at invalid position")
-					return
-				}
-				if len(stack[level].sub) > 2 {
-					err = f.error("else present more than once")
-					return
-				}
-				stack[level].sub = append(stack[level].sub, internalArray{})
-				stackArray[level] = &stack[level].sub[len(stack[level].sub)-1]
-				n = nil
-			case "elseif":
-				// create a new if in the current if's sub[2], and record it at the same level in the stack
-				if level < 1 || (stack[level].typ != internalIf) {
-					err = f.error("elseif at invalid position")
-					return
-				}
-				if len(stack[level].sub) > 2 {
-					err = f.error("elseif after else")
-					return
-				}
 
-				n.typ = internalIf
-				n.sub = make([]internalArray, 2) // will add one more if has else
-				if len(txt) <= 7 {
-					if len(f.data) > 1 {
-						f.data = f.data[1:]
+					n.typ = internalIf
+					n.sub = make([]internalArray, 2) // will add one more if has else
+					if len(txt) <= 7 {
+						if len(f.data) > 1 {
+							f.data = f.data[1:]
+						} else {
+							//hum? let's just avoid crashing
+							f.data = make(fragments, 0)
+						}
 					} else {
-						//hum? let's just avoid crashing
-						f.data = make(fragments, 0)
+						f.data[0].cut(7)
+					}
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // condition for {{if}}
+					if err != nil {
+						return
+					}
+					n.sub[1] = internalArray{}
+
+					// add this if as else of previous if
+					stack[level].sub = append(stack[level].sub, internalArray{n})
+					// then record it at the same level
+					stack[level] = n
+					stackArray[level] = &n.sub[1]
+				case "try":
+					n.typ = internalTry
+					n.sub = make([]internalArray, 1) // will add one more if has else
+					n.sub[0] = internalArray{}
+					level++
+					stack[level] = n
+					stackArray[level] = &n.sub[0]
+				case "catch":
+					// create a new if in the current if's sub[2], and record it at the same level in the stack
+					if level < 1 || (stack[level].typ != internalTry) {
+						err = f.error("catch at invalid position")
+						return
+					}
+					if len(stack[level].sub) > 1 {
+						err = f.error("catch after catch?")
+						return
 					}
-				} else {
-					f.data[0].text = f.data[0].text[7:]
-				}
-				n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // condition for {{if}}
-				if err != nil {
-					return
-				}
-				n.sub[1] = internalArray{}
 
-				// add this if as else of previous if
-				stack[level].sub = append(stack[level].sub, internalArray{n})
-				// then record it at the same level
-				stack[level] = n
-				stackArray[level] = &n.sub[1]
-			case "try":
-				n.typ = internalTry
-				n.sub = make([]internalArray, 1) // will add one more if has else
-				n.sub[0] = internalArray{}
-				level++
-				stack[level] = n
-				stackArray[level] = &n.sub[0]
-			case "catch":
-				// create a new if in the current if's sub[2], and record it at the same level in the stack
-				if level < 1 || (stack[level].typ != internalTry) {
-					err = f.error("catch at invalid position")
-					return
-				}
-				if len(stack[level].sub) > 1 {
-					err = f.error("catch after catch?")
-					return
-				}
+					if len(txt) <= 6 {
+						if len(f.data) > 1 {
+							err = f.error("invalid parameters in catch")
+							return
+						}
+					} else {
+						stack[level].str = strings.ToLower(strings.TrimSpace(txt[6:]))
+					}
 
-				if len(txt) <= 6 {
-					if len(f.data) > 1 {
-						err = f.error("invalid parameters in catch")
+					stack[level].sub = append(stack[level].sub, internalArray{})
+					stackArray[level] = &stack[level].sub[len(stack[level].sub)-1]
+					n = nil
+				case "/try":
+					if level < 1 || stack[level].typ != internalTry {
+						err = f.error("/try at invalid position")
+						return
+					}
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "break", "continue":
+					n.typ = internalBreak
+					if strings.ToLower(cmd) == "continue" {
+						n.typ = internalContinue
+					}
+					if !inForeach(stack, level) {
+						err = f.error("%s outside of foreach", cmd)
+						return
+					}
+					rest := strings.TrimSpace(txt[len(cmd):])
+					if rest == "" && len(f.data) == 1 {
+						break
+					}
+					switch {
+					case strings.ToLower(rest) == "if" && len(f.data) > 1:
+						f.data = f.data[1:]
+					case len(rest) > 3 && strings.ToLower(rest[:3]) == "if ":
+						f.data[0].cut(len(txt) - len(strings.TrimLeft(txt[len(cmd):], " \t\r\n")) + 3)
+					default:
+						err = f.error("%s invalid syntax", cmd)
 						return
 					}
-				} else {
-					stack[level].str = strings.ToLower(strings.TrimSpace(txt[6:]))
-				}
-
-				stack[level].sub = append(stack[level].sub, internalArray{})
-				stackArray[level] = &stack[level].sub[len(stack[level].sub)-1]
-				n = nil
-			case "/try":
-				if level < 1 || stack[level].typ != internalTry {
-					err = f.error("/try at invalid position")
-					return
-				}
-				level--
-				cur = stackArray[level]
-				n = nil
-			}
-			if n == nil {
-				break
-			}
-			if n.typ == internalInvalid {
-				// Check if the text looks like a numeric expression (starts with digit, decimal point, or minus sign)
-				// e.g., {{1+1}}, {{3.14}}, {{1 + 2 * 3}}, {{-5}}, {{-3.14}}, {{--5}}
-				trimmedTxt := strings.TrimSpace(txt)
-				if len(trimmedTxt) > 0 && (isDigit(trimmedTxt[0]) ||
-					(trimmedTxt[0] == '.' && len(trimmedTxt) > 1 && isDigit(trimmedTxt[1])) ||
-					(trimmedTxt[0] == '-' && len(trimmedTxt) > 1 && (isDigit(trimmedTxt[1]) || trimmedTxt[1] == '.' || trimmedTxt[1] == '-'))) {
-					// This looks like a numeric expression - parse as expression and output
-					n.typ = internalSub
 					n.sub = make([]internalArray, 1)
-					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // true = isExpression
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // condition for {{break if}}
+				case "switch":
+					n.typ = internalSwitch
+					n.sub = make([]internalArray, 2) // will add one more if has default
+					if len(txt) <= 7 {
+						if len(f.data) > 1 {
+							f.data = f.data[1:]
+						} else {
+							err = f.error("switch without value")
+							return
+						}
+					} else {
+						f.data[0].cut(7)
+					}
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // value for {{switch}}
 					if err != nil {
 						return
 					}
-					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
-					break
-				}
+					n.sub[1] = internalArray{}
+					level++
+					stack[level] = n
+					stackArray[level] = &internalArray{} // anything before the first case
+				case "case":
+					if level < 1 || stack[level].typ != internalSwitch {
+						err = f.error("case at invalid position")
+						return
+					}
+					if len(stack[level].sub) > 2 {
+						err = f.error("case after default")
+						return
+					}
+					// report content before the first case, and go on
+					errs.add("", checkSwitchBody(stack[level], stackArray[level]))
 
-				// consider it a link, but start by making text lowercase
-				pos := strings.IndexByte(txt, '/')
-				if pos > 0 {
-					f.data[0].text = strings.ToLower(txt[:pos]) + txt[pos:]
-				} else {
-					f.data[0].text = strings.ToLower(txt)
-				}
+					n.typ = internalCase
+					n.sub = make([]internalArray, 2)
+					if len(txt) <= 5 {
+						f.data = f.data[1:]
+					} else {
+						f.data[0].cut(5)
+					}
+					n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // values for {{case}}
+					if err != nil {
+						return
+					}
+					if len(n.sub[0]) == 0 {
+						err = f.error("case without value")
+						return
+					}
+					n.sub[1] = internalArray{}
 
-				// Process f.data in order, handling brackets inline
-				// Each bracket creates an internalIndex wrapping the current expression
-				// Text after a bracket becomes additional path components
-				var currentData fragments
-				for _, fd := range f.data {
-					if fd.ftyp == "[" {
-						// First, create the current node from accumulated data
-						if len(currentData) > 0 {
-							n.typ = internalLink
-							n.sub = make([]internalArray, 1)
-							n.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
-							if err != nil {
-								return
-							}
-							currentData = nil
+					stack[level].sub[1] = append(stack[level].sub[1], n)
+					stackArray[level] = &n.sub[1]
+					n = nil
+				case "default":
+					if level < 1 || stack[level].typ != internalSwitch {
+						err = f.error("default at invalid position")
+						return
+					}
+					if len(stack[level].sub) > 2 {
+						err = f.error("default present more than once")
+						return
+					}
+					// report content before the first case, and go on
+					errs.add("", checkSwitchBody(stack[level], stackArray[level]))
+					stack[level].sub = append(stack[level].sub, internalArray{})
+					stackArray[level] = &stack[level].sub[2]
+					n = nil
+				case "/switch":
+					if level < 1 || stack[level].typ != internalSwitch {
+						err = f.error("/switch at invalid position")
+						return
+					}
+					// report content before the first case, and go on
+					errs.add("", checkSwitchBody(stack[level], stackArray[level]))
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "block":
+					name := strings.ToLower(strings.TrimSpace(txt[5:]))
+					if name == "" {
+						// no name, this is an include of a template called "block"
+						break
+					}
+					n.typ = internalBlock
+					if len(f.data) != 1 || strings.ContainsAny(name, " \t\r\n") {
+						err = f.error("block invalid syntax")
+						return
+					}
+					n.str = name
+					n.sub = []internalArray{internalArray{}}
+					level++
+					stack[level] = n
+					stackArray[level] = &n.sub[0]
+				case "/block":
+					if level < 1 || stack[level].typ != internalBlock {
+						err = f.error("/block at invalid position")
+						return
+					}
+					level--
+					cur = stackArray[level]
+					n = nil
+				case "parent":
+					inBlock := false
+					for i := 1; i <= level; i++ {
+						if stack[i].typ == internalBlock {
+							inBlock = true
+							break
 						}
-
-						// Wrap current node with internalIndex
-						indexNode := fd.newNode()
-						indexNode.typ = internalIndex
-						indexNode.sub = make([]internalArray, 2)
-						indexNode.sub[0] = internalArray{n}                                    // base is the current node
-						indexNode.sub[1], err = e.compileTpl_step2_recurse(ctx, fd.data, true) // index expression
-						if err != nil {
+					}
+					if !inBlock || len(f.data) != 1 || strings.TrimSpace(txt) != "parent" {
+						// outside of a block this is an include of a template called "parent"
+						break
+					}
+					n.typ = internalParent
+				case "extends":
+					name := strings.ToLower(strings.TrimSpace(txt[7:]))
+					if name == "" {
+						// no name, this is an include of a template called "extends"
+						break
+					}
+					if len(f.data) != 1 || strings.ContainsAny(name, " \t\r\n") {
+						err = f.error("extends invalid syntax")
+						return
+					}
+					if isExpression || level != 0 {
+						err = f.error("extends at invalid position")
+						return
+					}
+					n.typ = internalExtends
+					n.str = name
+				case "include":
+					args := strings.TrimLeft(txt[7:], " \t\r\n")
+					name := args
+					if pos := strings.IndexAny(args, " \t\r\n="); pos != -1 {
+						name = args[:pos]
+					}
+					if name == "" {
+						// no name, this is an include of a template called "include"
+						break
+					}
+					if name[0] == '_' || name[0] == '$' {
+						err = f.error("include invalid syntax: template name expected")
+						return
+					}
+					f.data[0].cut(len(txt) - len(args) + len(name))
+					n.typ = internalInclude
+					n.str = strings.ToLower(name)
+					n.filters, err = e.compileAssignments(ctx, f.data, "include")
+					if err != nil {
+						return
+					}
+					seen := make(map[string]bool)
+					for _, p := range n.filters {
+						if seen[p.str] {
+							err = f.error("include parameter %s set more than once", p.str)
 							return
 						}
-						n = indexNode
-					} else {
-						// Accumulate text/other fragments
-						currentData = append(currentData, fd)
+						seen[p.str] = true
+					}
+					var flt internalArray
+					flt, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+					n.filters = append(n.filters, flt...)
+				case "params":
+					if strings.TrimSpace(txt) == "params" && len(f.data) == 1 {
+						// no parameters, this is an include of a template called "params"
+						break
 					}
+					if isExpression || level != 0 {
+						err = f.error("params at invalid position")
+						return
+					}
+					f.data[0].cut(6)
+					n.typ = internalParams
+					n.sub = make([]internalArray, 2)
+					n.sub[0] = internalArray{}
+					n.sub[1], err = e.compileParamsDecl(ctx, f.data)
 				}
-
-				// Process any remaining data after the last bracket
-				if len(currentData) > 0 {
-					if n.typ == internalIndex {
-						// We have path components after a bracket - need to apply them to the indexed result
-						// Convert remaining path to string and create a path-resolving wrapper
-						pathNode := f.newNode()
-						pathNode.typ = internalLink
-						pathNode.sub = make([]internalArray, 1)
-						// Prepend a placeholder that will be replaced by the indexed value
-						pathNode.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
+				if n == nil {
+					break
+				}
+				if n.typ == internalInvalid {
+					// Check if the text looks like a numeric expression (starts with digit, decimal point, or minus sign)
+					// e.g., {{1+1}}, {{3.14}}, {{1 + 2 * 3}}, {{-5}}, {{-3.14}}, {{--5}}
+					trimmedTxt := strings.TrimSpace(txt)
+					if len(trimmedTxt) > 0 && (isDigit(trimmedTxt[0]) ||
+						(trimmedTxt[0] == '.' && len(trimmedTxt) > 1 && isDigit(trimmedTxt[1])) ||
+						(trimmedTxt[0] == '-' && len(trimmedTxt) > 1 && (isDigit(trimmedTxt[1]) || trimmedTxt[1] == '.' || trimmedTxt[1] == '-'))) {
+						// This looks like a numeric expression - parse as expression and output
+						n.typ = internalSub
+						n.sub = make([]internalArray, 1)
+						n.sub[0], err = e.compileTpl_step2_recurse(ctx, f.data, true) // true = isExpression
 						if err != nil {
 							return
 						}
-						// Wrap: the internalIndex result needs to have the path applied
-						// We use internalIndex with the path as a "continuation"
-						// Actually, let's handle this differently - store path in str field
-						// For now, just append the path to be resolved at runtime
-						n.str = currentData[0].text // store the path like "/a" for runtime resolution
+						n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+						break
+					}
+
+					// consider it a link, but start by making text lowercase
+					pos := strings.IndexByte(txt, '/')
+					if pos > 0 {
+						f.data[0].text = strings.ToLower(txt[:pos]) + txt[pos:]
 					} else {
-						// No brackets yet - just create a normal link
-						n.typ = internalLink
-						n.sub = make([]internalArray, 1)
-						n.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
-						if err != nil {
-							return
+						f.data[0].text = strings.ToLower(txt)
+					}
+
+					// Process f.data in order, handling brackets inline
+					// Each bracket creates an internalIndex wrapping the current expression
+					// Text after a bracket becomes additional path components
+					var currentData fragments
+					for _, fd := range f.data {
+						if fd.ftyp == "[" {
+							// First, create the current node from accumulated data
+							if len(currentData) > 0 {
+								n.typ = internalLink
+								n.sub = make([]internalArray, 1)
+								n.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
+								if err != nil {
+									return
+								}
+								currentData = nil
+							}
+
+							// Wrap current node with internalIndex
+							n, err = e.compileIndex(ctx, fd, internalArray{n})
+							if err != nil {
+								return
+							}
+						} else {
+							// Accumulate text/other fragments
+							currentData = append(currentData, fd)
 						}
 					}
-				}
 
-				n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+					// Process any remaining data after the last bracket
+					if len(currentData) > 0 {
+						if n.typ == internalIndex || n.typ == internalSlice {
+							// We have path components after a bracket - need to apply them to the indexed result
+							// Convert remaining path to string and create a path-resolving wrapper
+							pathNode := f.newNode()
+							pathNode.typ = internalLink
+							pathNode.sub = make([]internalArray, 1)
+							// Prepend a placeholder that will be replaced by the indexed value
+							pathNode.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
+							if err != nil {
+								return
+							}
+							// Wrap: the internalIndex result needs to have the path applied
+							// We use internalIndex with the path as a "continuation"
+							// Actually, let's handle this differently - store path in str field
+							// For now, just append the path to be resolved at runtime
+							n.str = currentData[0].text // store the path like "/a" for runtime resolution
+						} else {
+							// No brackets yet - just create a normal link
+							n.typ = internalLink
+							n.sub = make([]internalArray, 1)
+							n.sub[0], err = e.compileTpl_step2_recurse(ctx, currentData, false)
+							if err != nil {
+								return
+							}
+						}
+					}
+
+					n.filters, err = e.compileTpl_step2_recurse(ctx, f.linkextra, false)
+				}
 			}
-		}
+			return
+		}()
 
 		if err != nil {
-			break Loop
+			if isExpression {
+				return
+			}
+			// skip this fragment and continue, so all errors can be reported at once
+			errs.add("", err)
+			err = nil
+			if n != nil && isBlockStart(n.typ) {
+				// the opening tag failed, keep it on the stack so its
+				// content and closing tag do not cause more errors
+				level++
+				n.sub = make([]internalArray, 2)
+				stack[level] = n
+				stackArray[level] = &n.sub[1]
+				failed[n] = true
+			}
+			continue
 		}
 
 		if n != nil {
@@ -801,177 +1475,522 @@ Loop:
 		}
 	}
 
-	if level != 0 {
+	if level != 0 && !failed[stack[level]] {
 		// something isn't closed on the stack
-		err = stack[level].error("element isn't closed")
-		return
+		errs.add("", stack[level].error("element isn't closed"))
 	}
 
-	// if not an expression, processing ends here
-	if !isExpression {
-		return
+	err = errs.err()
+	return
+}
+
+// isBlockStart returns true for nodes opened by a tag that has a closing tag
+func isBlockStart(typ internalType) bool {
+	switch typ {
+	case internalForeach, internalIf, internalSet, internalSwitch, internalBlock:
+		return true
 	}
+	return false
+}
 
-	// range over res to check things that needs to be made recursive (operators, etc)
-	// we should always have an operator between values
-	var last_comma *internalNode
+// parseForeachVars parses the variables following "as" in foreach, either
+// "_v" or "_k => _v"
+func parseForeachVars(f *fragment, vars string) (key, val string, err error) {
+	val = strings.TrimSpace(vars)
+	if k, v, ok := strings.Cut(val, "=>"); ok {
+		key, val = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		if len(key) < 2 || key[0] != '_' || strings.ContainsAny(key, " \t\r\n") {
+			return "", "", f.error("foreach invalid key variable")
+		}
+	}
+	val = strings.ToLower(val)
+	if len(val) < 2 || val[0] != '_' || strings.ContainsAny(val, " \t\r\n") {
+		return "", "", f.error("foreach invalid syntax")
+	}
+	if key == val {
+		return "", "", f.error("foreach key and value variables must be different")
+	}
+	return
+}
+
+// inForeach returns true if the current position in the stack is within the
+// body of a foreach
+func inForeach(stack map[int]*internalNode, level int) bool {
+	for ; level > 0; level-- {
+		if stack[level].typ == internalForeach {
+			// not in the else part
+			return len(stack[level].sub) == 2
+		}
+	}
+	return false
+}
 
-	for {
-		has_op := false
-		op_pos := 0
-		op_weight := 999
+// checkSwitchBody makes sure nothing but whitespace appears in a {{switch}}
+// before its first {{case}} or {{default}}
+func checkSwitchBody(sw *internalNode, body *internalArray) error {
+	if len(sw.sub[1]) > 0 || len(sw.sub) > 2 {
+		// body belongs to a case or default
+		return nil
+	}
+	for _, n := range *body {
+		if n.typ != internalText || strings.TrimSpace(n.str) != "" {
+			return n.error("content in switch outside of case")
+		}
+	}
+	return nil
+}
 
-		// look for operator with lowest weight
-		for i, n := range res {
-			if n.typ == internalOperator && len(n.sub) == 0 {
-				has_op = true
-				op_w := math_operators[n.str]
-				if op_w == 0 {
-					// syntax error
-					return nil, n.error("invalid operator %s", n.str)
-				}
-				if op_w < op_weight {
-					op_weight = op_w
-					op_pos = i
-				}
+// tokenize splits the text of an expression fragment into values and
+// operators, appended to res. If the text ends with a function name such as
+// @func, it is returned as call, without its arguments.
+func (f *fragment) tokenize(res internalArray) (_ internalArray, call *internalNode, err error) {
+	txt := f.text
+	line, char := f.line, f.char
+	at := 0
+	// node returns a new node at the position of txt[j]
+	node := func(j int) *internalNode {
+		for ; at < j; at++ {
+			if txt[at] == '\n' {
+				line++
+				char = 1
+			} else {
+				char++
 			}
 		}
+		n := f.newNode()
+		n.line, n.char = line, char
+		return n
+	}
 
-		// Special handling for consecutive unary operators at the start (e.g., --5, !!x)
-		// Process the rightmost one first (closest to operand)
-		if op_pos == 0 && len(res) > 2 {
-			// Check how many consecutive unprocessed operators we have at the start
-			lastUnaryPos := 0
-			for i := 0; i < len(res)-1; i++ {
-				if res[i].typ == internalOperator && len(res[i].sub) == 0 {
-					// Check if this could be a unary operator (!, ~, or - followed by value/another unary)
-					opStr := res[i].str
-					if opStr == "!" || opStr == "~" || opStr == "-" {
-						lastUnaryPos = i
-					} else {
-						break
-					}
-				} else {
-					break
+	var num *internalNode
+	flush := func() {
+		if num != nil {
+			res = append(res, num)
+			num = nil
+		}
+	}
+
+	for j := 0; j < len(txt); j++ {
+		c := txt[j]
+		switch {
+		case isSpace(c):
+			flush()
+		case isDigit(c) || (c == '.' && (j+1 >= len(txt) || txt[j+1] != '.')):
+			if num == nil {
+				num = node(j)
+				num.typ = internalText
+			}
+			num.str += string(c)
+		case c == '_' && num == nil:
+			// variable, such as _x or _x/path
+			l := varNameLen(txt[j:])
+			n := node(j)
+			n.typ = internalLink
+			name := node(j)
+			name.typ = internalText
+			name.str = txt[j : j+l]
+			n.sub = []internalArray{internalArray{name}}
+			res = append(res, n)
+			j += l - 1
+		case c == '@' && num == nil:
+			// function call, the arguments are in the next fragment
+			l := varNameLen(txt[j:])
+			call = node(j)
+			call.typ = internalFunc
+			call.str = txt[j+1 : j+l]
+			if call.str == "" || strings.TrimSpace(txt[j+l:]) != "" {
+				return nil, nil, call.error("expected @function(...) call")
+			}
+			return res, call, nil
+		default:
+			flush()
+			op, l := mathKeywordOperator(txt[j:])
+			if op == "" && j < len(txt)-1 {
+				if _, ok := math_operators[txt[j:j+2]]; ok {
+					op, l = txt[j:j+2], 2
 				}
 			}
-			// If we have multiple consecutive unary operators, start with the rightmost
-			if lastUnaryPos > 0 {
-				op_pos = lastUnaryPos
-			}
-		}
-
-		// Handle case where the selected operator is followed by a unary operator (e.g., 5 - -3)
-		// We need to process the unary operator first
-		if op_pos > 0 && op_pos+1 < len(res) {
-			next := res[op_pos+1]
-			if next.typ == internalOperator && len(next.sub) == 0 {
-				nextStr := next.str
-				if nextStr == "!" || nextStr == "~" || nextStr == "-" {
-					// Find the last consecutive unary operator starting from op_pos+1
-					lastUnaryPos := op_pos + 1
-					for i := op_pos + 1; i < len(res)-1; i++ {
-						if res[i].typ == internalOperator && len(res[i].sub) == 0 {
-							opStr := res[i].str
-							if opStr == "!" || opStr == "~" || opStr == "-" {
-								lastUnaryPos = i
-							} else {
-								break
-							}
-						} else {
-							break
-						}
-					}
-					op_pos = lastUnaryPos
+			if op == "" {
+				if _, ok := math_operators[txt[j:j+1]]; ok {
+					op, l = txt[j:j+1], 1
 				}
 			}
+			n := node(j)
+			if op == "" {
+				return nil, nil, n.error("unhandled char %c", c)
+			}
+			n.typ = internalOperator
+			n.str = op
+			res = append(res, n)
+			j += l - 1
 		}
-		if !has_op {
-			break
-		}
+	}
+	flush()
+	return res, nil, nil
+}
+
+// compileFunc compiles into n a call to function name, with its arguments in
+// the ( fragment args. If the function can run at compile time and the
+// arguments are static, n is replaced with the result.
+func (e *Page) compileFunc(ctx context.Context, n *internalNode, name string, args *fragment) error {
+	var err error
+	n.typ = internalFunc
+	n.str = name
+	if err := e.Policy.checkFunction(name); err != nil {
+		return n.subError(err, "%s", err)
+	}
+	n.sub = make([]internalArray, 1)
+	n.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{args}, true)
+	if err != nil {
+		return err
+	}
+	fnc, ok := e.registry().Function(n.str)
+	if !ok || !fnc.CanCompile || !n.sub[0].isStatic() {
+		return nil
+	}
+	params, err := n.sub[0].ToValues(ctx)
+	if err != nil {
+		return nil
+	}
+	t := &interfaceValue{}
+	if err := fnc.Method(ctx, params, t); err != nil {
+		return n.subError(err, "error running method %s: %s", n.str, err)
+	}
+	// update things
+	n.typ = internalValue
+	n.str = ""
+	n.sub = nil
+	n.value = t
+	return nil
+}
 
-		n := res[op_pos]
+// compileExpression compiles the content of the (, [ or { fragment f as an
+// expression. Filters apply to the value right before them, and what follows
+// a filter is the rest of the expression.
+func (e *Page) compileExpression(ctx context.Context, f *fragment) (internalArray, error) {
+	res, err := e.compileTpl_step2_tokens(ctx, internalArray{}, f.data, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, fx := range f.linkextra {
+		if len(res) == 0 || isOperatorToken(res[len(res)-1]) {
+			return nil, fx.error("expected value before filter")
+		}
+		if len(fx.data) < 2 || fx.data[0].ftyp != "text" || fx.data[1].ftyp != "(" {
+			return nil, fx.error("invalid filter call")
+		}
+		flt := fx.newNode()
+		flt.typ = internalFilter
+		flt.str = strings.TrimSpace(fx.data[0].text)
+		flt.sub = make([]internalArray, 1)
+		flt.sub[0], err = e.compileTpl_step2_recurse(ctx, fx.data[1:2], true)
+		if err != nil {
+			return nil, err
+		}
+		last := res[len(res)-1]
+		last.filters = append(last.filters, flt)
 
-		if op_pos == len(res)-1 {
-			return nil, n.error("invalid operator %s at end of expression", n.str)
+		res, err = e.compileTpl_step2_tokens(ctx, res, fx.data[2:], true)
+		if err != nil {
+			return nil, err
 		}
+	}
+	return parseExpression(res)
+}
 
-		next_n := res[op_pos+1]
+// varNameLen returns the length of the variable name at the start of txt.
+// A / is only part of the name when followed by a letter, so _a/2 remains a
+// division.
+func varNameLen(txt string) int {
+	for i := 1; i < len(txt); i++ {
+		c := txt[i]
+		switch {
+		case c == '_' || isDigit(c) || unicode.IsLetter(rune(c)):
+		case c == '/' && i+1 < len(txt) && unicode.IsLetter(rune(txt[i+1])):
+		default:
+			return i
+		}
+	}
+	return len(txt)
+}
 
-		if op_pos == 0 {
-			if n.str == "!" || n.str == "~" {
-				// special case for unary operators
-				n.sub = []internalArray{internalArray{next_n}}
-				res = res[1:]
-				res[0] = n
-				continue
-			}
-			if n.str == "-" {
-				// unary minus: convert to (0 - x)
-				zeroNode := n.e.makeValueNode(n.tpl, n.line, n.char, int64(0))
-				n.sub = []internalArray{internalArray{zeroNode}, internalArray{next_n}}
-				res = res[1:]
-				res[0] = n
-				continue
-			}
-			return nil, n.error("invalid operator %s at start of expression", n.str)
+// compileIndex compiles the [...] fragment f applied to base, either as an
+// index access or as a [from:to] slice
+func (e *Page) compileIndex(ctx context.Context, f *fragment, base internalArray) (*internalNode, error) {
+	n := f.newNode()
+	from, to, isSlice := splitSlice(f.data)
+	if !isSlice {
+		n.typ = internalIndex
+		idx, err := e.compileExpression(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		n.sub = []internalArray{base, idx}
+		return n, nil
+	}
+	n.typ = internalSlice
+	n.sub = []internalArray{base, nil, nil}
+	for i, part := range []fragments{from, to} {
+		sub, err := e.compileTpl_step2_recurse(ctx, part, true)
+		if err != nil {
+			return nil, err
 		}
+		n.sub[i+1] = sub
+	}
+	return n, nil
+}
 
-		if n.str == "!" || n.str == "~" {
-			// special case for unary operators at non-zero position
-			n.sub = []internalArray{internalArray{next_n}}
-			copy(res[op_pos:], res[op_pos+1:])
-			res[len(res)-1] = nil
-			res[op_pos] = n
-			res = res[:len(res)-1]
+// splitSlice splits the content of a [from:to] fragment at its ":". A "?"
+// means the ":" belongs to a ternary operator, not a slice.
+func splitSlice(data fragments) (fragments, fragments, bool) {
+	for _, f := range data {
+		if f.ftyp == "text" && strings.IndexByte(f.text, '?') != -1 {
+			return nil, nil, false
+		}
+	}
+	for i, f := range data {
+		if f.ftyp != "text" {
 			continue
 		}
+		p := strings.IndexByte(f.text, ':')
+		if p == -1 {
+			continue
+		}
+		left, right := *f, *f
+		left.text = f.text[:p]
+		right.text = f.text[p+1:]
+		right.char += p + 1
+		from := append(append(fragments{}, data[:i]...), &left)
+		to := append(fragments{&right}, data[i+1:]...)
+		return from, to, true
+	}
+	return nil, nil, false
+}
 
-		prev_n := res[op_pos-1]
+// literalItems returns the items of a compiled literal, separated by commas
+func literalItems(a internalArray) []internalArray {
+	switch {
+	case len(a) == 0:
+		return nil
+	case len(a) == 1 && a[0].typ == internalList:
+		return a[0].sub
+	default:
+		return []internalArray{a}
+	}
+}
 
-		// Handle unary minus at non-zero position (when preceded by another operator)
-		// e.g., in "--5", the second "-" is unary
-		if n.str == "-" && prev_n.typ == internalOperator && len(prev_n.sub) == 0 {
-			// unary minus: convert to (0 - x)
-			zeroNode := n.e.makeValueNode(n.tpl, n.line, n.char, int64(0))
-			n.sub = []internalArray{internalArray{zeroNode}, internalArray{next_n}}
-			copy(res[op_pos:], res[op_pos+1:])
-			res[len(res)-1] = nil
-			res[op_pos] = n
-			res = res[:len(res)-1]
-			continue
+// compileArrayLit compiles a [a, b, ...] array literal into n
+func (e *Page) compileArrayLit(ctx context.Context, f *fragment, n *internalNode) error {
+	content, err := e.compileExpression(ctx, f)
+	if err != nil {
+		return err
+	}
+	n.typ = internalArrayLit
+	n.sub = literalItems(content)
+	return n.compileStaticLit(ctx)
+}
+
+// compileMapLit compiles a {"key": value, ...} map literal into n
+func (e *Page) compileMapLit(ctx context.Context, f *fragment, n *internalNode) error {
+	content, err := e.compileExpression(ctx, f)
+	if err != nil {
+		return err
+	}
+	n.typ = internalMapLit
+	n.sub = []internalArray{}
+	for _, item := range literalItems(content) {
+		if len(item) != 1 || item[0].typ != internalOperator || item[0].str != ":" || len(item[0].sub) != 2 {
+			return f.error("invalid map literal, expected key: value")
+		}
+		n.sub = append(n.sub, item[0].sub...)
+	}
+	return n.compileStaticLit(ctx)
+}
+
+// compileStaticLit turns a literal into an internalValue if all its items
+// are static
+func (n *internalNode) compileStaticLit(ctx context.Context) error {
+	for _, x := range n.sub {
+		if !x.isStatic() {
+			return nil
+		}
+	}
+	v := &interfaceValue{}
+	if err := n.run(ctx, v); err != nil {
+		return err
+	}
+	n.typ = internalValue
+	n.value = v
+	n.sub = nil
+	return nil
+}
+
+// checkOperators reports operators that remained unused after compilation
+func (a internalArray) checkOperators() error {
+	return a.walk(func(n *internalNode) error {
+		if n.typ == internalOperator && n.str == ":" {
+			return n.error("unexpected : outside of map literal")
+		}
+		return nil
+	})
+}
+
+// compileAssignments compiles a list of _VAR=value assignments as found in
+// {{set}} or {{include}} into internalVar nodes. Assignments are separated by
+// spaces, such as {{include CARD _title=_x _price=2}}. A value can be in the
+// next fragment, as in _title="value", and words that are not an assignment
+// continue the previous value, as in _total=_a + 1.
+func (e *Page) compileAssignments(ctx context.Context, data fragments, instr string) (res internalArray, err error) {
+	for len(data) > 0 {
+		fd := data[0]
+		if fd.ftyp != "text" {
+			err = fd.error("invalid %s instruction", instr)
+			return
+		}
+
+		var nVar *internalNode
+		var value []string
+		flush := func() error {
+			if len(value) == 0 {
+				return fd.error("invalid %s instruction: missing value for %s", instr, nVar.str)
+			}
+			synthFrag := &fragment{
+				ftyp: "text",
+				text: strings.Join(value, " "),
+				ctx:  fd.ctx,
+				line: fd.line,
+				char: fd.char,
+			}
+			var err error
+			nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{synthFrag}, true)
+			if err != nil {
+				return err
+			}
+			res = append(res, nVar)
+			return nil
 		}
 
-		if n.str == "," {
-			if last_comma != nil {
-				// we already got a comma, group what we have here with it
-				if prev_n != last_comma {
-					return nil, n.error("invalid comma position")
+		for _, w := range strings.Fields(fd.text) {
+			name, val, ok := assignmentWord(w)
+			if !ok {
+				if nVar == nil {
+					err = fd.error("invalid %s instruction: missing =", instr)
+					return
 				}
-				// we need to remove cur and next, not prev
-				prev_n.sub = append(prev_n.sub, internalArray{next_n})
-				copy(res[op_pos:], res[op_pos+2:])
-				res[len(res)-1] = nil
-				res[len(res)-2] = nil
-				res = res[:len(res)-2]
+				value = append(value, w)
 				continue
-			} else {
-				// special case
-				n.typ = internalList
-				n.str = ""
-				last_comma = n
+			}
+			if nVar != nil {
+				if err = flush(); err != nil {
+					return
+				}
+			}
+			name = strings.ToLower(name)
+			if name[0] != '_' {
+				err = fd.error("invalid %s instruction: variable must start with _", instr)
+				return
+			}
+			nVar = fd.newNode()
+			nVar.typ = internalVar
+			nVar.str = name
+			nVar.sub = make([]internalArray, 1)
+			value = nil
+			if val != "" {
+				value = append(value, val)
 			}
 		}
+		data = data[1:]
 
-		// remove prev/next from res
-		copy(res[op_pos-1:], res[op_pos+1:])
-		res[len(res)-1] = nil
-		res[len(res)-2] = nil
-		res[op_pos-1] = n
-		res = res[:len(res)-2]
+		if nVar == nil {
+			// Skip empty text fragments
+			continue
+		}
+		if len(value) == 0 && len(data) > 0 {
+			// Value is in next fragment (e.g., {{set _I="value"}})
+			nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, data[0:1], true)
+			if err != nil {
+				return
+			}
+			res = append(res, nVar)
+			data = data[1:]
+			continue
+		}
+		if err = flush(); err != nil {
+			return
+		}
+	}
+	return
+}
 
-		n.sub = []internalArray{internalArray{prev_n}, internalArray{next_n}}
+// assignmentWord splits a word such as _VAR=value into the name and the
+// value. It returns false if w does not start with a name followed by =,
+// such as in _a==1.
+func assignmentWord(w string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(w, "=")
+	if !ok || name == "" || strings.HasPrefix(value, "=") {
+		return "", "", false
+	}
+	for _, c := range name {
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return "", "", false
+		}
+	}
+	return name, value, true
+}
 
+// compileParamsDecl compiles the parameters declared by {{params}}, returning
+// an internalVar for each, with the default value in Sub[0] if any.
+func (e *Page) compileParamsDecl(ctx context.Context, data fragments) (res internalArray, err error) {
+	seen := make(map[string]bool)
+	for i := 0; i < len(data); i++ {
+		fd := data[i]
+		if fd.ftyp != "text" {
+			err = fd.error("invalid params instruction")
+			return
+		}
+		words := strings.Fields(fd.text)
+		for j, w := range words {
+			name, val, hasVal := strings.Cut(w, "=")
+			name = strings.ToLower(name)
+			if len(name) < 2 || name[0] != '_' {
+				err = fd.error("invalid params instruction: parameter must start with _")
+				return
+			}
+			if seen[name] {
+				err = fd.error("parameter %s declared more than once", name)
+				return
+			}
+			seen[name] = true
+
+			nVar := fd.newNode()
+			nVar.typ = internalVar
+			nVar.str = name
+			if hasVal {
+				nVar.sub = make([]internalArray, 1)
+				if val == "" {
+					// default value is in next fragment, eg. _title="untitled"
+					if j != len(words)-1 || !strings.HasSuffix(strings.TrimSpace(fd.text), "=") || i+1 >= len(data) {
+						err = fd.error("invalid params instruction: missing value for %s", name)
+						return
+					}
+					i++
+					nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, data[i:i+1], true)
+				} else {
+					synthFrag := &fragment{
+						ftyp: "text",
+						text: val,
+						ctx:  fd.ctx,
+						line: fd.line,
+						char: fd.char,
+					}
+					nVar.sub[0], err = e.compileTpl_step2_recurse(ctx, fragments{synthFrag}, true)
+				}
+				if err != nil {
+					return
+				}
+			}
+			res = append(res, nVar)
+		}
 	}
 	return
 }
@@ -92,6 +92,8 @@ func (a *internalArray) Dump(o io.Writer, lvl int) {
 
 func (e *Page) Dump(o io.Writer, lvl int) {
 	pfx := strings.Repeat("\t", lvl)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	for t, a := range e.compiled {
 		fmt.Fprintf(o, "%sTpl(%s)\n", pfx, t)
 		a.Dump(o, lvl+1)
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -15,12 +16,45 @@ type Page struct {
 	Version int
 	// Raw contains the template source data
 	Raw RawData
-	// compiled holds the processed templates ready for execution
+	// compiled holds the processed templates ready for execution, use
+	// lookup to access it
 	compiled map[string]internalArray
+	// mu protects compiled
+	mu sync.RWMutex
+	// compileMu serializes changes to templates
+	compileMu sync.Mutex
 
 	// MaxProcess controls parallel execution of templates
 	// 0 means unlimited concurrency, 1 means serial execution
 	MaxProcess int
+
+	// TrimBlocks removes whitespace-only lines around block tags such as
+	// {{if}}, {{foreach}} or {{set}} when compiling
+	TrimBlocks bool
+
+	// Strict rejects references to undefined filters, functions and
+	// templates at compile time, and makes reading undefined variables or
+	// indexes an error at run time. The ?? operator can still be used to
+	// read values that may not exist.
+	Strict bool
+
+	// AutoEscape escapes the output of each {{...}} according to its HTML
+	// context, such as text, attribute, URL, <script> or <style>, when the
+	// MIME type of the page is HTML. It can also be set with the AutoEscape
+	// page property.
+	AutoEscape bool
+
+	// Limits restricts the resources used to run templates of the page. It
+	// can be replaced for a given context with WithLimits.
+	Limits Limits
+
+	// Policy restricts the functions and filters templates can call. If
+	// nil, all of them can be called.
+	Policy *Policy
+
+	// Registry holds the functions and filters of the page. If nil, the
+	// ones registered with RegisterFunction and RegisterFilter are used.
+	Registry *Registry
 }
 
 // New creates a new template engine instance.
@@ -80,8 +114,18 @@ func (n *internalNode) internalParseLink(ctx context.Context, out *interfaceValu
 	}
 	if val == nil {
 		key = strings.ToLower(key)
-		if tpl, ok := n.e.compiled[key]; ok {
-			val = tpl.WithCtx(ctx) // keep context in value so when we resolve it we have vars
+		if tpl, ok := n.e.lookup(key); ok {
+			ctx, err := n.enter(ctx)
+			if err != nil {
+				return err
+			}
+			depth, _ := ctx.Value(ctxDepth).(int)
+			val = nestedTpl{tpl, depth}.WithCtx(ctx) // keep context in value so when we resolve it we have vars
+		} else if n.e.strict(ctx) {
+			if key[0] == '_' || key[0] == '$' {
+				return n.error("undefined variable %s", keyA[0])
+			}
+			return n.error("undefined template %s", key)
 		} else {
 			// calling a non-existent link is not an error
 			LogDebug(ctx, "Accessing non-existing key returns null", "key", key)
@@ -92,9 +136,9 @@ func (n *internalNode) internalParseLink(ctx context.Context, out *interfaceValu
 	// we always have val at this point
 	if len(keyA) > 1 {
 		for _, s := range keyA[1:] {
-			val, err = ResolveValueIndex(ctx, val, s)
+			val, err = n.resolveIndex(ctx, val, s)
 			if err != nil {
-				return n.subError(err, "failed to access array: %s", err)
+				return err
 			}
 		}
 	}
@@ -133,74 +177,76 @@ func (tpl internalArray) run(ctx context.Context, out *interfaceValue) (err erro
 		return nil
 	}
 
-	// Run concurrently
+	// Run concurrently. Results are read in order, so an error or a break
+	// or continue hides the errors and output of the nodes after it.
 	wg := &sync.WaitGroup{}
 	wg.Add(len(tpl))
 	tOut := make([]*interfaceValue, len(tpl))
-	errorC := make(chan error, len(tpl))
-
-	// Create a separate context that we can cancel if needed
-	execCtx, cancel := context.WithCancel(ctx)
-	defer cancel() // Ensure all goroutines are canceled when we exit
+	nodeErr := make([]error, len(tpl))
+
+	// Each node gets a context that is canceled when a node before it fails
+	// or stops the loop
+	cancels := make([]context.CancelFunc, len(tpl))
+	nodeCtx := make([]context.Context, len(tpl))
+	for i := range tpl {
+		nodeCtx[i], cancels[i] = context.WithCancel(ctx)
+		defer cancels[i]()
+	}
+	stopAfter := func(i int) {
+		for _, c := range cancels[i+1:] {
+			c()
+		}
+	}
 
 	for i, n := range tpl {
 		tOut[i] = &interfaceValue{}
 
-		go func(o *interfaceValue, n *internalNode) {
+		go func(i int, o *interfaceValue, n *internalNode) {
 			defer wg.Done()
 			defer func() {
 				if r := recover(); r != nil {
-					select {
-					case errorC <- n.error("panic: %s", r):
-						LogError(ctx, n.error("panic: %s", r), "Panic in template execution")
-					default:
-						// Channel might be closed if we're already handling errors
-					}
+					nodeErr[i] = n.error("panic: %s", r)
+					LogError(ctx, nodeErr[i], "Panic in template execution")
+					stopAfter(i)
 				}
 			}()
 
-			// Run with the cancellable context
-			e := n.run(execCtx, o)
-			if e != nil {
-				select {
-				case errorC <- e:
-					// Signal other goroutines to stop
-					cancel()
-				default:
-					// Channel might be closed if we're already handling errors
-				}
+			if e := n.run(nodeCtx[i], o); e != nil {
+				nodeErr[i] = e
+				stopAfter(i)
 			}
-		}(tOut[i], n)
+		}(i, tOut[i], n)
 	}
 	wg.Wait()
 
 	// Check if original context was canceled during execution
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
-	// Read all errors from channel
-	if len(errorC) > 0 {
-		err = <-errorC
-		close(errorC)
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	close(errorC)
-
-	// Write output from all nodes
-	for _, lOut := range tOut {
+	// Write output from all nodes, up to an error, a break or a continue
+	for i, lOut := range tOut {
+		if nodeErr[i] != nil && !isLoopControl(nodeErr[i]) {
+			return nodeErr[i]
+		}
 		if err = out.WriteValue(ctx, lOut); err != nil {
 			LogError(ctx, err, "Error writing template value")
 			return err
 		}
+		if nodeErr[i] != nil {
+			return nodeErr[i]
+		}
 	}
 
 	return nil
 }
 
+// runBlockChain runs the first version of a block in chain, making the
+// remaining ones available to {{parent}}.
+func runBlockChain(ctx context.Context, chain []internalArray, out *interfaceValue) error {
+	return chain[0].run(context.WithValue(ctx, ctxBlockParent, chain[1:]), out)
+}
+
 // ReadValue executes the template array and returns its value.
 func (n internalArray) ReadValue(ctx context.Context) (any, error) {
 	buf := &interfaceValue{}
@@ -262,13 +308,113 @@ func (n *internalNode) isStatic() bool {
 		return true
 	case internalSub:
 		return n.sub[0].isStatic()
+	case internalArrayLit, internalMapLit:
+		for _, x := range n.sub {
+			if !x.isStatic() {
+				return false
+			}
+		}
+		return true
 	case internalValue:
-		return false // n.value → can it be dynamic? yes.
+		// n.value can be dynamic, unless it contains plain data
+		v, ok := n.value.(*interfaceValue)
+		return ok && isPlainValue(v.val)
+	default:
+		return false
+	}
+}
+
+// resolvePath resolves the additional path stored in n.str (like "/a") on
+// the result of an index or slice access
+func (n *internalNode) resolvePath(ctx context.Context, result any) (any, error) {
+	if n.str == "" {
+		return result, nil
+	}
+	var err error
+	for _, part := range strings.Split(n.str, "/") {
+		if part == "" {
+			continue
+		}
+		result, err = n.resolveIndex(ctx, result, part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// resolveIndex returns index s of v. In strict mode, accessing an index that
+// does not exist is an error.
+func (n *internalNode) resolveIndex(ctx context.Context, v any, s string) (any, error) {
+	res, err := ResolveValueIndex(ctx, v, s)
+	if err != nil {
+		return nil, n.subError(err, "failed to access index [%s]: %s", s, err)
+	}
+	if res == nil && n.e.strict(ctx) && !hasIndex(ctx, v, s) {
+		return nil, n.error("undefined index %s", s)
+	}
+	return res, nil
+}
+
+// strict returns true if undefined values are an error in ctx
+func (e *Page) strict(ctx context.Context) bool {
+	return e.Strict && ctx.Value(ctxLenient) == nil
+}
+
+// isPlainValue returns true if v only contains basic types that will not
+// change once set
+func isPlainValue(v any) bool {
+	switch v := v.(type) {
+	case nil, string, bool, int, int64, uint64, float64:
+		return true
+	case []any:
+		for _, x := range v {
+			if !isPlainValue(x) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		for _, x := range v {
+			if !isPlainValue(x) {
+				return false
+			}
+		}
+		return true
 	default:
 		return false
 	}
 }
 
+// literalValue returns the value of a in a form suitable to be stored in an
+// array or map literal
+func literalValue(ctx context.Context, a internalArray) (any, error) {
+	if len(a) == 1 && a[0].typ == internalText && a[0].filters == nil {
+		// number
+		if i, err := strconv.ParseInt(a[0].str, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(a[0].str, 64); err == nil {
+			return f, nil
+		}
+	}
+	v := &interfaceValue{}
+	if err := a.run(ctx, v); err != nil {
+		return nil, err
+	}
+	raw, err := v.WithCtx(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	switch b := raw.(type) {
+	case *bytes.Buffer:
+		return b.String(), nil
+	case []byte:
+		return string(b), nil
+	}
+	return raw, nil
+}
+
 // ToValues converts the array's value to Values type.
 // If the value is already a Values type, it's returned as is.
 // Otherwise, it wraps the value in a single-element Values slice.
@@ -293,7 +439,16 @@ func (a internalArray) ToValues(ctx context.Context) (Values, error) {
 }
 
 func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
+	lim := limiterOf(ctx)
+	if lim != nil {
+		if err := lim.step(n); err != nil {
+			return err
+		}
+	}
 	target := out
+	if n.esc.state != escNone || n.output && lim != nil && lim.MaxOutput > 0 && n.typ != internalText {
+		target = new(interfaceValue)
+	}
 	if len(n.filters) > 0 {
 		// variable setting filters
 		v := make(map[string]interface{})
@@ -321,6 +476,11 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 
 	switch n.typ {
 	case internalText:
+		if n.output && lim != nil {
+			if err := lim.write(n, n.str); err != nil {
+				return err
+			}
+		}
 		target.Write([]byte(n.str))
 	case internalLink:
 		err := n.internalParseLink(ctx, target)
@@ -337,15 +497,85 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 		for i, x := range n.sub {
 			res[i] = x.WithCtx(ctx)
 		}
-		out.WriteValue(ctx, res)
+		target.WriteValue(ctx, res)
+	case internalArrayLit:
+		res := make([]any, len(n.sub))
+		for i, x := range n.sub {
+			v, err := literalValue(ctx, x)
+			if err != nil {
+				return err
+			}
+			res[i] = v
+		}
+		if err := target.WriteValue(ctx, res); err != nil {
+			return err
+		}
+	case internalMapLit:
+		res := make(map[string]any, len(n.sub)/2)
+		for i := 0; i+1 < len(n.sub); i += 2 {
+			k, err := literalValue(ctx, n.sub[i])
+			if err != nil {
+				return err
+			}
+			v, err := literalValue(ctx, n.sub[i+1])
+			if err != nil {
+				return err
+			}
+			res[NewValue(k).WithCtx(ctx).String()] = v
+		}
+		if err := target.WriteValue(ctx, res); err != nil {
+			return err
+		}
 	case internalOperator:
 		switch n.str {
+		case "?":
+			// ternary, only the selected side is evaluated
+			cond := &interfaceValue{}
+			if err := n.sub[0].run(ctx, cond); err != nil {
+				return err
+			}
+			side := n.sub[2]
+			if cond.AsBool(ctx) {
+				side = n.sub[1]
+			}
+			if err := side.run(ctx, target); err != nil {
+				return err
+			}
+		case "??":
+			// first non-null value, undefined values are allowed on the left
+			v := &interfaceValue{}
+			if err := n.sub[0].run(context.WithValue(ctx, ctxLenient, true), v); err != nil {
+				return err
+			}
+			raw, err := v.ReadValue(ctx)
+			if err != nil {
+				return err
+			}
+			if raw != nil {
+				err = target.WriteValue(ctx, v)
+			} else {
+				err = n.sub[1].run(ctx, target)
+			}
+			if err != nil {
+				return err
+			}
+		case "in", "not in":
+			res, err := mathInOperator(ctx, n.sub[0], n.sub[1])
+			if err != nil {
+				return n.subError(err, "operator failed: %s", err)
+			}
+			if n.str == "not in" {
+				res = !res
+			}
+			if err = target.WriteValue(ctx, res); err != nil {
+				return err
+			}
 		case "!", "~":
 			// special operator (only one argument)
 			if res, err := mathSingleValueOperator(ctx, n.str, n.sub[0]); err != nil {
 				return n.subError(err, "operator failed: %s", err)
 			} else {
-				err = out.WriteValue(ctx, res)
+				err = target.WriteValue(ctx, res)
 				if err != nil {
 					return err
 				}
@@ -354,7 +584,7 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 			if res, err := mathValueOperator(ctx, n.str, n.sub[0], n.sub[1]); err != nil {
 				return n.subError(err, "operator failed: %s", err)
 			} else {
-				err = out.WriteValue(ctx, res)
+				err = target.WriteValue(ctx, res)
 				if err != nil {
 					return err
 				}
@@ -363,23 +593,57 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 	case internalForeach:
 		// handle value in sub[0] and foreach on it
 		varName := n.str
-
-		var prevVal interface{}
-		cnt, err := foreachAny(ctx, n.sub[0], func(k, v interface{}, idx, max int64) error {
+		depth, _ := ctx.Value(ctxForeachDepth).(int64)
+		depth++
+		loopCtx := context.WithValue(ctx, ctxForeachDepth, depth)
+
+		// items are run one step late so the next one is known
+		var prevVal, curKey, curVal interface{}
+		var curIdx, curMax int64
+		runItem := func(nxt interface{}, last bool) error {
 			nv := map[string]interface{}{
-				varName + "_max": NewValue(max),
-				varName:          NewValue(v),
-				varName + "_key": NewValue(k),
-				varName + "_idx": NewValue(idx),
-				varName + "_prv": NewValue(prevVal),
+				varName + "_max":   NewValue(curMax),
+				varName:            NewValue(curVal),
+				varName + "_key":   NewValue(curKey),
+				varName + "_idx":   NewValue(curIdx),
+				varName + "_prv":   NewValue(prevVal),
+				varName + "_nxt":   NewValue(nxt),
+				varName + "_first": NewValue(curIdx == 1),
+				varName + "_last":  NewValue(last),
+				varName + "_odd":   NewValue(curIdx%2 == 1),
+				varName + "_even":  NewValue(curIdx%2 == 0),
+				varName + "_depth": NewValue(depth),
 			}
-			if err := n.sub[1].run(ValuesCtx(ctx, nv), target); err != nil {
+			if n.key != "" {
+				nv[n.key] = NewValue(curKey)
+			}
+			if err := n.sub[1].run(ValuesCtx(loopCtx, nv), target); err != nil && err != errLoopContinue {
 				return err
 			}
 
-			prevVal = v
+			prevVal = curVal
+			return nil
+		}
+		cnt, err := foreachAny(ctx, n.sub[0], func(k, v interface{}, idx, max int64) error {
+			if lim != nil {
+				if err := lim.iterate(n); err != nil {
+					return err
+				}
+			}
+			if idx > 1 {
+				if err := runItem(v, false); err != nil {
+					return err
+				}
+			}
+			curKey, curVal, curIdx, curMax = k, v, idx, max
 			return nil
 		})
+		if err == nil && cnt > 0 {
+			err = runItem(nil, true)
+		}
+		if err == errLoopBreak {
+			err = nil
+		}
 		if err != nil {
 			return n.subError(err, "error in foreach: %s", err)
 		}
@@ -407,29 +671,74 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 				}
 			}
 		}
-	case internalFunc:
-		// process call to function in str
-		if f, ok := ctx.Value("@" + n.str).(TplFuncCallback); ok {
-			// custom in-context function
-			params, err := n.sub[0].ToValues(ctx)
-			if err != nil {
+	case internalBreak, internalContinue:
+		if len(n.sub) > 0 {
+			cond := &interfaceValue{}
+			if err := n.sub[0].run(ctx, cond); err != nil {
 				return err
 			}
-			if err := f(ctx, params, target); err != nil {
-				return n.subError(err, "function call failed: %s", err)
+			if !cond.AsBool(ctx) {
+				break
 			}
-		} else if f, ok := tplFunctions[n.str]; ok {
-			// only call if ok
-			params, err := n.sub[0].ToValues(ctx)
+		}
+		if n.typ == internalBreak {
+			return errLoopBreak
+		}
+		return errLoopContinue
+	case internalSwitch:
+		// value in sub[0] is evaluated once and compared to each case
+		val := &interfaceValue{}
+		if err := n.sub[0].run(ctx, val); err != nil {
+			return err
+		}
+		o1, err := val.WithCtx(ctx).Raw()
+		if err != nil {
+			return err
+		}
+		var body internalArray
+		if len(n.sub) > 2 {
+			body = n.sub[2]
+		}
+	SwitchLoop:
+		for _, c := range n.sub[1] {
+			vals, err := c.sub[0].ToValues(ctx)
 			if err != nil {
-				return n.subError(err, "failed to prepare method arguments: %s", err)
+				return err
 			}
-			if err := f.Method(ctx, params, target); err != nil {
-				return n.subError(err, "function call failed: %s", err)
+			for _, v := range vals {
+				o2, err := v.WithCtx(ctx).Raw()
+				if err != nil {
+					return err
+				}
+				r, err := CompareValues(ctx, o1, o2)
+				if err != nil {
+					return err
+				}
+				if r {
+					body = c.sub[1]
+					break SwitchLoop
+				}
 			}
-		} else {
+		}
+		if err := body.run(ctx, target); err != nil {
+			return err
+		}
+	case internalFunc:
+		if err := n.e.Policy.checkFunction(n.str); err != nil {
+			return n.subError(err, "%s", err)
+		}
+		// process call to function in str, from the context or the registry
+		f, ok := n.e.function(ctx, n.str)
+		if !ok {
 			return n.error("tpl: call to undefined function %s", n.str)
 		}
+		params, err := n.sub[0].ToValues(ctx)
+		if err != nil {
+			return n.subError(err, "failed to prepare method arguments: %s", err)
+		}
+		if err := f(ctx, params, target); err != nil {
+			return n.subError(err, "function call failed: %s", err)
+		}
 	case internalSet:
 		if err := n.sub[0].run(ctx, target); err != nil {
 			return err
@@ -441,6 +750,17 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 	case internalTry:
 		t := new(interfaceValue)
 		if err := n.sub[0].run(ctx, t); err != nil {
+			if isLoopControl(err) {
+				// not an error, keep what was output so far
+				if err2 := target.WriteValue(ctx, t); err2 != nil {
+					return err2
+				}
+				return err
+			}
+			if isLimit(err) || ctx.Err() != nil {
+				// cannot be caught
+				return err
+			}
 			// catch the error
 			if len(n.sub) > 1 {
 				ctx2 := ctx
@@ -454,8 +774,9 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 					return err2
 				}
 			}
-		} else {
-			target.WriteValue(ctx, t)
+		} else if err := target.WriteValue(ctx, t); err != nil {
+			// linked templates are run when written, limits can be reached here
+			return err
 		}
 	case internalValue:
 		target.WriteValue(ctx, n.value)
@@ -480,26 +801,118 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 		indexKey := indexVal.WithCtx(ctx).String()
 
 		// Resolve the indexed value
-		result, err := ResolveValueIndex(ctx, base, indexKey)
+		result, err := n.resolveIndex(ctx, base, indexKey)
 		if err != nil {
-			return n.subError(err, "failed to access index [%s]: %s", indexKey, err)
+			return err
 		}
 
-		// If there's additional path in str (like "/a"), resolve it
-		if n.str != "" {
-			pathParts := strings.Split(n.str, "/")
-			for _, part := range pathParts {
-				if part == "" {
-					continue
-				}
-				result, err = ResolveValueIndex(ctx, result, part)
-				if err != nil {
-					return n.subError(err, "failed to access path [%s]: %s", part, err)
-				}
+		result, err = n.resolvePath(ctx, result)
+		if err != nil {
+			return err
+		}
+
+		target.WriteValue(ctx, result)
+	case internalSlice:
+		baseVal := &interfaceValue{}
+		if err := n.sub[0].run(ctx, baseVal); err != nil {
+			return err
+		}
+		base, err := baseVal.ReadValue(ctx)
+		if err != nil {
+			return n.subError(err, "failed to read base value: %s", err)
+		}
+
+		// evaluate bounds, empty if not specified
+		var bounds [2]string
+		for i, sub := range n.sub[1:] {
+			if len(sub) == 0 {
+				continue
 			}
+			v := &interfaceValue{}
+			if err := sub.run(ctx, v); err != nil {
+				return err
+			}
+			bounds[i] = v.WithCtx(ctx).String()
+		}
+
+		result, err := ResolveValueSlice(ctx, base, bounds[0], bounds[1])
+		if err != nil {
+			return n.subError(err, "failed to access slice [%s:%s]: %s", bounds[0], bounds[1], err)
+		}
+		result, err = n.resolvePath(ctx, result)
+		if err != nil {
+			return err
 		}
 
 		target.WriteValue(ctx, result)
+	case internalBlock:
+		// overriding versions of this block come first, then our own content
+		overrides, _ := ctx.Value(ctxBlocks).(map[string][]internalArray)
+		chain := overrides[n.str]
+		chain = append(chain[:len(chain):len(chain)], n.sub[0])
+		if err := runBlockChain(ctx, chain, target); err != nil {
+			return err
+		}
+	case internalParent:
+		if chain, _ := ctx.Value(ctxBlockParent).([]internalArray); len(chain) > 0 {
+			if err := runBlockChain(ctx, chain, target); err != nil {
+				return err
+			}
+		}
+	case internalExtends:
+		parent, ok := n.e.lookup(n.str)
+		if !ok {
+			return n.error("tpl: extends non-existing template %s", n.str)
+		}
+		ctx, err := n.enter(ctx)
+		if err != nil {
+			return err
+		}
+		// our blocks go after the ones of the templates extending us
+		prev, _ := ctx.Value(ctxBlocks).(map[string][]internalArray)
+		blocks := make(map[string][]internalArray, len(prev)+len(n.sub))
+		for k, v := range prev {
+			blocks[k] = v
+		}
+		for _, b := range n.sub {
+			chain := blocks[b[0].str]
+			blocks[b[0].str] = append(chain[:len(chain):len(chain)], b[0].sub[0])
+		}
+		if err := parent.run(context.WithValue(ctx, ctxBlocks, blocks), target); err != nil {
+			return err
+		}
+	case internalInclude:
+		// parameters have been set in ctx as variables by the filters
+		tpl, ok := n.e.lookup(n.str)
+		if !ok {
+			return n.error("tpl: include of non-existing template %s", n.str)
+		}
+		ctx, err := n.enter(ctx)
+		if err != nil {
+			return err
+		}
+		if err := tpl.run(ctx, target); err != nil {
+			return err
+		}
+	case internalParams:
+		// set default values for parameters that were not passed
+		v := make(map[string]interface{})
+		for _, p := range n.sub[1] {
+			if ctx.Value(p.str) != nil {
+				continue
+			}
+			if len(p.sub) == 0 {
+				return n.error("tpl: missing required parameter %s", p.str)
+			}
+			val, err := p.sub[0].ReadValue(ctx)
+			if err != nil {
+				return err
+			}
+			v[p.str] = val
+		}
+		if err := n.sub[0].run(ValuesCtx(ctx, v), target); err != nil {
+			return err
+		}
 	default:
 		return n.error("unable to process node of type %s", n.typ.String())
 		//n.Dump(out, 1)
@@ -511,7 +924,10 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 			if f.typ != internalFilter {
 				continue
 			}
-			if flt, ok := tplfilters[f.str]; ok {
+			if err := n.e.Policy.checkFilter(f.str); err != nil {
+				return f.subError(err, "%s", err)
+			}
+			if flt, ok := n.e.filter(ctx, f.str); ok {
 				params, err := f.sub[0].WithCtx(ctx).Raw()
 				if err != nil {
 					return err
@@ -523,8 +939,13 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 						vparams = Values{AsOutValue(ctx, params)}
 					}
 				}
+				in := target
+				if s, ok := safeString(target.val); ok {
+					// filters see trusted content as a string
+					in = &interfaceValue{s}
+				}
 				newtarget := &interfaceValue{}
-				if err := flt(ctx, vparams, target, newtarget); err != nil {
+				if err := flt(ctx, vparams, in, newtarget); err != nil {
 					return n.subError(err, "failed to run filter %s: %s", f.str, err)
 				}
 				target = newtarget
@@ -532,6 +953,14 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 				return n.error("tpl: call to undefined filter %s", f.str)
 			}
 		}
+		if n.output && lim != nil {
+			if err := lim.write(n, target.val); err != nil {
+				return err
+			}
+		}
+		if n.esc.state != escNone {
+			return n.escapeOutput(ctx, target, out)
+		}
 		return out.WriteValue(ctx, target)
 	}
 
@@ -540,10 +969,18 @@ func (n *internalNode) run(ctx context.Context, out *interfaceValue) error {
 
 // HasTpl returns true if the template exists in the compiled templates.
 func (e *Page) HasTpl(tpl string) bool {
-	_, ok := e.compiled[tpl]
+	_, ok := e.lookup(tpl)
 	return ok
 }
 
+// lookup returns the compiled template tpl
+func (e *Page) lookup(tpl string) (internalArray, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	a, ok := e.compiled[tpl]
+	return a, ok
+}
+
 // Parse executes the named template in the given context, writing output to the provided interfaceValue.
 // Returns ErrTplNotFound if the template doesn't exist.
 func (e *Page) Parse(ctx context.Context, tpl string, out *interfaceValue) error {
@@ -552,11 +989,11 @@ func (e *Page) Parse(ctx context.Context, tpl string, out *interfaceValue) error
 		return err
 	}
 
-	tplData, ok := e.compiled[tpl]
+	tplData, ok := e.lookup(tpl)
 	if !ok {
 		return ErrTplNotFound
 	}
-	return tplData.run(ctx, out)
+	return tplData.run(e.withLimiter(ctx), out)
 }
 
 // ParseAndWrite executes the named template in the given context, writing output to the provided io.Writer.
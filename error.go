@@ -4,14 +4,32 @@ package tpl
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // Common template errors.
 var (
 	// ErrTplNotFound is returned when a requested template is not found.
 	ErrTplNotFound = errors.New("tpl: Template not found")
+	// ErrInvalidCache is returned by UnmarshalBinary for data it cannot decode.
+	ErrInvalidCache = errors.New("tpl: invalid template cache")
+	// ErrCacheVersion is returned by UnmarshalBinary for data written by an
+	// incompatible version, in which case templates must be compiled again.
+	ErrCacheVersion = errors.New("tpl: unsupported template cache version")
 )
 
+// loop control, returned by {{break}} and {{continue}} up to the enclosing foreach
+var (
+	errLoopBreak    = errors.New("tpl: break outside of foreach")
+	errLoopContinue = errors.New("tpl: continue outside of foreach")
+)
+
+// isLoopControl returns true if err is used to control the enclosing foreach
+func isLoopControl(err error) bool {
+	return err == errLoopBreak || err == errLoopContinue
+}
+
 // Error is a template error, containing details such as where an error occurred
 // in the template source and details on the actual error.
 // It implements the error interface and supports error wrapping with Unwrap().
@@ -59,3 +77,59 @@ func (e *Error) Is(target error) bool {
 		e.Char == t.Char &&
 		e.Message == t.Message
 }
+
+// ErrorList is a list of template errors, returned by Compile so all errors
+// in a page can be reported at once. Errors are sorted by template, line and
+// character. errors.As and errors.Is apply to each error in the list.
+type ErrorList []*Error
+
+// Error returns the errors in the list, one per line.
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.String()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors in the list, enabling compatibility with errors.Is
+// and errors.As.
+func (l ErrorList) Unwrap() []error {
+	res := make([]error, len(l))
+	for i, e := range l {
+		res[i] = e
+	}
+	return res
+}
+
+// add appends err to the list. Lists are flattened and other errors are
+// wrapped in an *Error for template tpl.
+func (l *ErrorList) add(tpl string, err error) {
+	switch e := err.(type) {
+	case nil:
+	case ErrorList:
+		*l = append(*l, e...)
+	case *Error:
+		*l = append(*l, e)
+	default:
+		*l = append(*l, &Error{Message: err.Error(), Template: tpl, Parent: err})
+	}
+}
+
+// err returns the list sorted as an error, or nil if it is empty
+func (l ErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		if a.Template != b.Template {
+			return a.Template < b.Template
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Char < b.Char
+	})
+	return l
+}
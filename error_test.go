@@ -1,6 +1,7 @@
 package tpl_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -101,3 +102,100 @@ func TestErrTplNotFound(t *testing.T) {
 		t.Errorf("ErrTplNotFound has incorrect message: %v", tpl.ErrTplNotFound.Error())
 	}
 }
+
+func TestCompileErrorList(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = "{{(1 + )}}\n{{/if}}\n{{include other}}"
+	engine.Raw.TemplateData["other"] = "ok {{/foreach}}"
+	engine.Raw.TemplateData["third"] = "x {{if 1}}"
+	engine.Raw.TemplateData["fourth"] = "{{include other}}\n{{include nosuch}}"
+
+	err := engine.Compile(context.Background())
+	if err == nil {
+		t.Fatalf("expected compile error")
+	}
+
+	var list tpl.ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("expected tpl.ErrorList, got %T", err)
+	}
+
+	expected := []string{
+		"At fourth on line 2 (position 1): include of non-existing template nosuch",
+		"At main on line 1 (position 6): expected value, found end of expression",
+		"At main on line 2 (position 1): /if at invalid position",
+		"At other on line 1 (position 4): /foreach at invalid position",
+		"At third on line 1 (position 3): element isn't closed",
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("got %d errors, want %d:\n%s", len(list), len(expected), err)
+	}
+	for i, e := range list {
+		if e.String() != expected[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.String(), expected[i])
+		}
+	}
+
+	// each error can be reached with errors.As and errors.Is
+	var first *tpl.Error
+	if !errors.As(err, &first) || first != list[0] {
+		t.Errorf("errors.As did not return the first error")
+	}
+	if !errors.Is(err, list[3]) {
+		t.Errorf("errors.Is did not match an error of the list")
+	}
+}
+
+func TestCompileErrorCascade(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"switch_outside_case", "{{switch 1}}x{{case 1}}one{{/switch}}", []string{
+			"At main on line 1 (position 13): content in switch outside of case",
+		}},
+		{"switch_no_case", "{{switch 1}}x{{/switch}}", []string{
+			"At main on line 1 (position 13): content in switch outside of case",
+		}},
+		{"foreach_syntax", "{{foreach _l as _k =>}}{{_k}}{{/foreach}}", []string{
+			"At main on line 1 (position 1): foreach invalid syntax",
+		}},
+		{"foreach_empty", "{{foreach}}{{/foreach}}", []string{
+			"At main on line 1 (position 1): foreach invalid syntax",
+		}},
+		{"switch_empty", "{{switch}}{{case 1}}a{{/switch}}", []string{
+			"At main on line 1 (position 1): switch without value",
+		}},
+		{"nested_failed", "{{if 1}}{{foreach}}{{/foreach}}{{/if}}", []string{
+			"At main on line 1 (position 9): foreach invalid syntax",
+		}},
+		{"failed_not_closed", "{{foreach}}a", []string{
+			"At main on line 1 (position 1): foreach invalid syntax",
+		}},
+		{"error_inside_failed", "{{foreach}}{{(1 + )}}{{/foreach}}", []string{
+			"At main on line 1 (position 1): foreach invalid syntax",
+			"At main on line 1 (position 17): expected value, found end of expression",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			err := engine.Compile(context.Background())
+			var list tpl.ErrorList
+			if !errors.As(err, &list) {
+				t.Fatalf("expected tpl.ErrorList, got %v", err)
+			}
+			if len(list) != len(tt.expected) {
+				t.Fatalf("got %d errors, want %d:\n%s", len(list), len(tt.expected), err)
+			}
+			for i, e := range list {
+				if e.String() != tt.expected[i] {
+					t.Errorf("error %d: got %q, want %q", i, e.String(), tt.expected[i])
+				}
+			}
+		})
+	}
+}
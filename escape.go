@@ -0,0 +1,753 @@
+package tpl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// escState is the part of an HTML document an interpolation appears in
+type escState uint8
+
+const (
+	escNone        escState = iota // not escaped
+	escText                        // HTML text
+	escTag                         // in a tag, before an attribute name
+	escAttrName                    // in an attribute name
+	escAfterName                   // after an attribute name, before =
+	escBeforeValue                 // after =, before the attribute value
+	escAttr                        // in an attribute value
+	escURL                         // in a URL attribute value
+	escJS                          // in JavaScript code
+	escJSDqStr                     // in a JavaScript "string"
+	escJSSqStr                     // in a JavaScript 'string'
+	escJSTmplStr                   // in a JavaScript `template`
+	escJSLineCmt                   // in a JavaScript // comment
+	escJSBlockCmt                  // in a JavaScript /* comment */
+	escCSS                         // in CSS code
+	escComment                     // in an HTML <!-- comment -->
+)
+
+var escStateNames = [...]string{
+	escNone:        "unescaped output",
+	escText:        "HTML text",
+	escTag:         "tag",
+	escAttrName:    "attribute name",
+	escAfterName:   "attribute name",
+	escBeforeValue: "attribute",
+	escAttr:        "attribute value",
+	escURL:         "URL",
+	escJS:          "JavaScript",
+	escJSDqStr:     "JavaScript string",
+	escJSSqStr:     "JavaScript string",
+	escJSTmplStr:   "JavaScript template string",
+	escJSLineCmt:   "JavaScript comment",
+	escJSBlockCmt:  "JavaScript comment",
+	escCSS:         "CSS",
+	escComment:     "HTML comment",
+}
+
+// escJSQuotes are the characters ending JavaScript strings
+var escJSQuotes = map[escState]byte{escJSDqStr: '"', escJSSqStr: '\'', escJSTmplStr: '`'}
+
+// escDelim is the character ending the current attribute value
+type escDelim uint8
+
+const (
+	escDelimNone   escDelim = iota // not in an attribute value
+	escDelimDouble                 // "
+	escDelimSingle                 // '
+	escDelimSpace                  // unquoted, ends with a space or >
+)
+
+// escAttrType is the kind of content of an attribute value
+type escAttrType uint8
+
+const (
+	escAttrNormal escAttrType = iota
+	escAttrURL
+	escAttrJS
+	escAttrCSS
+)
+
+// escURLPart is the part of a URL an interpolation appears in
+type escURLPart uint8
+
+const (
+	escURLStart escURLPart = iota // nothing written yet, the scheme is checked
+	escURLPath                    // after the start of the URL
+	escURLQuery                   // after ? or #, values are fully encoded
+)
+
+// escElement is the element whose content is not HTML
+type escElement uint8
+
+const (
+	escElemNone escElement = iota
+	escElemScript
+	escElemStyle
+)
+
+// escContext is the HTML context at a given point of a template. The zero
+// value disables escaping.
+type escContext struct {
+	state escState
+	delim escDelim
+	attr  escAttrType
+	url   escURLPart
+	elem  escElement
+}
+
+func (c escContext) String() string {
+	res := escStateNames[c.state]
+	if c.delim != escDelimNone && c.state != escAttr {
+		res += " in attribute value"
+	}
+	return res
+}
+
+// urlAttrs lists attributes containing a URL, in addition to the ones with
+// a name containing "url" or "uri"
+var urlAttrs = map[string]bool{
+	"action": true, "archive": true, "background": true, "cite": true,
+	"classid": true, "codebase": true, "data": true, "formaction": true,
+	"href": true, "icon": true, "longdesc": true, "manifest": true,
+	"ping": true, "poster": true, "profile": true, "src": true,
+	"usemap": true, "xmlns": true,
+}
+
+// escAttrTypeOf returns the kind of content of attribute name
+func escAttrTypeOf(name string) escAttrType {
+	name = strings.ToLower(name)
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		// namespaced, such as xlink:href
+		name = name[i+1:]
+	}
+	name = strings.TrimPrefix(name, "data-")
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return escAttrJS
+	case name == "style":
+		return escAttrCSS
+	case urlAttrs[name], strings.Contains(name, "url"), strings.Contains(name, "uri"):
+		return escAttrURL
+	}
+	return escAttrNormal
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isTagNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// text returns the context after static text s
+func (c escContext) text(s string) escContext {
+	if c.state == escNone {
+		return c
+	}
+	for i := 0; i < len(s); {
+		if c.delim != escDelimNone {
+			// attribute values end at their delimiter whatever they contain
+			ch := s[i]
+			if c.delim == escDelimDouble && ch == '"' || c.delim == escDelimSingle && ch == '\'' {
+				c = escContext{state: escTag, elem: c.elem}
+				i++
+				continue
+			}
+			if c.delim == escDelimSpace && (isHTMLSpace(ch) || ch == '>') {
+				c = escContext{state: escTag, elem: c.elem}
+				continue
+			}
+		} else if c.elem == escElemScript && c.state >= escJS && c.state <= escJSBlockCmt && hasPrefixFold(s[i:], "</script") {
+			c = escContext{state: escTag}
+			i += len("</script")
+			continue
+		} else if c.elem == escElemStyle && c.state == escCSS && hasPrefixFold(s[i:], "</style") {
+			c = escContext{state: escTag}
+			i += len("</style")
+			continue
+		}
+
+		switch c.state {
+		case escText:
+			j := strings.IndexByte(s[i:], '<')
+			if j < 0 {
+				return c
+			}
+			i += j + 1
+			if strings.HasPrefix(s[i:], "!--") {
+				c.state = escComment
+				i += 3
+				continue
+			}
+			closing := strings.HasPrefix(s[i:], "/")
+			j = i
+			if closing {
+				j++
+			}
+			k := j
+			for k < len(s) && isTagNameChar(s[k]) {
+				k++
+			}
+			if k == j {
+				// not a tag
+				continue
+			}
+			c = escContext{state: escTag}
+			if !closing {
+				switch strings.ToLower(s[j:k]) {
+				case "script":
+					c.elem = escElemScript
+				case "style":
+					c.elem = escElemStyle
+				}
+			}
+			i = k
+		case escComment:
+			j := strings.Index(s[i:], "-->")
+			if j < 0 {
+				return c
+			}
+			c.state = escText
+			i += j + 3
+		case escTag, escAttrName:
+			ch := s[i]
+			switch {
+			case ch == '>':
+				i++
+				switch c.elem {
+				case escElemScript:
+					c = escContext{state: escJS, elem: c.elem}
+				case escElemStyle:
+					c = escContext{state: escCSS, elem: c.elem}
+				default:
+					c = escContext{state: escText}
+				}
+			case c.state == escTag && (isHTMLSpace(ch) || ch == '/'):
+				i++
+			default:
+				j := i
+				for j < len(s) && !isHTMLSpace(s[j]) && s[j] != '=' && s[j] != '>' && s[j] != '/' {
+					j++
+				}
+				if c.state == escTag {
+					// a name continued after an interpolation keeps its type
+					c.attr = escAttrTypeOf(s[i:j])
+				}
+				c.state = escAttrName
+				if j < len(s) {
+					c.state = escAfterName
+				}
+				i = j
+			}
+		case escAfterName:
+			switch ch := s[i]; {
+			case isHTMLSpace(ch):
+				i++
+			case ch == '=':
+				c.state = escBeforeValue
+				i++
+			default:
+				c = escContext{state: escTag, elem: c.elem}
+			}
+		case escBeforeValue:
+			switch ch := s[i]; {
+			case isHTMLSpace(ch):
+				i++
+			case ch == '>':
+				c = escContext{state: escTag, elem: c.elem}
+			default:
+				c = c.value()
+				switch ch {
+				case '"':
+					c.delim = escDelimDouble
+					i++
+				case '\'':
+					c.delim = escDelimSingle
+					i++
+				}
+			}
+		case escURL:
+			if ch := s[i]; ch == '?' || ch == '#' {
+				c.url = escURLQuery
+			} else if c.url == escURLStart {
+				c.url = escURLPath
+			}
+			i++
+		case escJS:
+			switch s[i] {
+			case '"':
+				c.state = escJSDqStr
+			case '\'':
+				c.state = escJSSqStr
+			case '`':
+				c.state = escJSTmplStr
+			case '/':
+				if strings.HasPrefix(s[i:], "//") {
+					c.state = escJSLineCmt
+					i++
+				} else if strings.HasPrefix(s[i:], "/*") {
+					c.state = escJSBlockCmt
+					i++
+				}
+			}
+			i++
+		case escJSDqStr, escJSSqStr, escJSTmplStr:
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == escJSQuotes[c.state] {
+				c.state = escJS
+			}
+			i++
+		case escJSLineCmt:
+			if s[i] == '\n' {
+				c.state = escJS
+			}
+			i++
+		case escJSBlockCmt:
+			if strings.HasPrefix(s[i:], "*/") {
+				c.state = escJS
+				i++
+			}
+			i++
+		default:
+			// attribute values and CSS are not tracked further
+			i++
+		}
+	}
+	return c
+}
+
+// value returns the context at the start of an unquoted value for the
+// attribute of c
+func (c escContext) value() escContext {
+	res := escContext{state: escAttr, delim: escDelimSpace, attr: c.attr, elem: c.elem}
+	switch c.attr {
+	case escAttrURL:
+		res.state = escURL
+	case escAttrJS:
+		res.state = escJS
+	case escAttrCSS:
+		res.state = escCSS
+	}
+	return res
+}
+
+// interp returns the context used to escape an interpolation in c, and the
+// context following it
+func (c escContext) interp() (escContext, escContext) {
+	switch c.state {
+	case escNone:
+		return c, c
+	case escBeforeValue:
+		return c.value().interp()
+	case escTag, escAfterName:
+		c = escContext{state: escAttrName, elem: c.elem}
+		return c, c
+	case escURL:
+		after := c
+		if c.url == escURLStart {
+			after.url = escURLPath
+		}
+		return c, after
+	}
+	return c, c
+}
+
+// join returns the context following branches ending in a and b
+func (n *internalNode) join(a, b escContext) (escContext, error) {
+	if a == b {
+		return a, nil
+	}
+	if a.state == escURL && b.state == escURL {
+		// use the strictest escaping of both parts
+		x, y := a, b
+		x.url, y.url = 0, 0
+		if x == y {
+			if a.url == escURLQuery || b.url == escURLQuery {
+				x.url = escURLQuery
+			}
+			return x, nil
+		}
+	}
+	return a, n.error("branches end in different contexts: %s and %s", a, b)
+}
+
+// markOutput flags the nodes of a written to the output of the template, as
+// opposed to the ones in expressions, and sets their escaping according to
+// their HTML context starting with c. It returns the context at the end of a.
+func (a internalArray) markOutput(c escContext) (escContext, error) {
+	for _, n := range a {
+		var err error
+		if c, err = n.markOutput(c); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func (n *internalNode) markOutput(c escContext) (escContext, error) {
+	switch n.typ {
+	case internalText:
+		if len(n.filters) == 0 {
+			n.output = true
+			return c.text(n.str), nil
+		}
+	case internalIf:
+		then, err := n.sub[1].markOutput(c)
+		if err != nil {
+			return c, err
+		}
+		els := c
+		if len(n.sub) > 2 {
+			if els, err = n.sub[2].markOutput(c); err != nil {
+				return c, err
+			}
+		}
+		return n.join(then, els)
+	case internalForeach:
+		// the body may run any number of times, it must not change the context
+		for _, sub := range n.sub[1:] {
+			end, err := sub.markOutput(c)
+			if err != nil {
+				return c, err
+			}
+			if _, err = n.join(c, end); err != nil {
+				return c, err
+			}
+		}
+		return c, nil
+	case internalTry:
+		// output of a failed try is discarded
+		res, err := n.sub[0].markOutput(c)
+		if err != nil {
+			return c, err
+		}
+		catch := c
+		if len(n.sub) > 1 {
+			if catch, err = n.sub[1].markOutput(c); err != nil {
+				return c, err
+			}
+		}
+		if res, err = n.join(res, catch); err != nil {
+			return c, err
+		}
+		return n.join(res, c)
+	case internalSwitch:
+		res := c
+		if len(n.sub) > 2 {
+			var err error
+			if res, err = n.sub[2].markOutput(c); err != nil {
+				return c, err
+			}
+		}
+		for _, cs := range n.sub[1] {
+			end, err := cs.sub[1].markOutput(c)
+			if err != nil {
+				return c, err
+			}
+			if res, err = cs.join(res, end); err != nil {
+				return c, err
+			}
+		}
+		return res, nil
+	case internalSet, internalParams, internalBlock:
+		return n.sub[0].markOutput(c)
+	case internalExtends:
+		// blocks replace the ones of the parent, assumed to be in HTML text
+		start := c
+		if c.state != escNone {
+			start = escContext{state: escText}
+		}
+		for _, b := range n.sub {
+			if _, err := b.markOutput(start); err != nil {
+				return c, err
+			}
+		}
+		return c, nil
+	case internalInclude, internalParent, internalBreak, internalContinue:
+		// output of templates is already escaped
+		return c, nil
+	}
+	n.output = true
+	n.esc, c = c.interp()
+	return c, nil
+}
+
+// outputContext returns the context templates start in when compiling
+func (e *Page) outputContext() (escContext, error) {
+	escape, err := e.autoEscape()
+	if err != nil || !escape {
+		return escContext{}, err
+	}
+	return escContext{state: escText}, nil
+}
+
+// autoEscape returns true if templates must be escaped when compiling. The
+// AutoEscape property can be "html" to always escape, "auto" to escape if
+// the page has an HTML MIME type, or "none". If it is not set, the AutoEscape
+// field of the page is used, as for "auto".
+func (e *Page) autoEscape() (bool, error) {
+	switch p, ok := e.Raw.PageProperties["AutoEscape"]; {
+	case !ok:
+		if !e.AutoEscape {
+			return false, nil
+		}
+	case p == "html":
+		return true, nil
+	case p == "none":
+		return false, nil
+	case p != "auto":
+		return false, fmt.Errorf("tpl: invalid AutoEscape property %q", p)
+	}
+	typ, _, _ := mime.ParseMediaType(e.GetMime())
+	return typ == "text/html" || typ == "application/xhtml+xml", nil
+}
+
+// escapeOutput writes v to out, escaped for the context of n. Trusted
+// content is only written as is in the context it is made for.
+func (n *internalNode) escapeOutput(ctx context.Context, v, out *interfaceValue) error {
+	var trusted any
+	if vc, ok := v.val.(*ValueCtx); ok {
+		switch vc.Value.(type) {
+		case internalArray, nestedTpl:
+			// output of a template
+			trusted = SafeHTML("")
+		}
+	}
+	raw, err := v.WithCtx(ctx).Raw()
+	if err != nil {
+		return err
+	}
+	switch raw.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if n.esc.state != escJS {
+			// nothing to escape
+			return out.WriteValue(ctx, v)
+		}
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		trusted = raw
+	}
+	_, isHTML := trusted.(SafeHTML)
+	if isHTML {
+		switch n.esc.state {
+		case escText, escComment, escAttrName:
+			return out.WriteValue(ctx, v)
+		}
+	}
+
+	s, err := v.WithCtx(ctx).StringErr()
+	if err != nil {
+		return err
+	}
+
+	switch n.esc.state {
+	case escText, escComment:
+		_, err = out.WriteString(html.EscapeString(s))
+		return err
+	case escAttrName:
+		s = attrNameFilter(s)
+	case escURL:
+		_, isURL := trusted.(SafeURL)
+		switch {
+		case isURL, n.esc.url == escURLPath:
+			s = urlNormalize(s)
+		case n.esc.url == escURLStart:
+			s = urlNormalize(urlFilter(s))
+		default:
+			s = urlQueryEscape(s)
+		}
+	case escJS:
+		if _, ok := trusted.(SafeJS); !ok {
+			if s, err = jsValue(raw); err != nil {
+				return err
+			}
+		}
+	case escJSDqStr, escJSSqStr, escJSTmplStr, escJSLineCmt, escJSBlockCmt:
+		s = jsStringEscape(s)
+	case escCSS:
+		if _, ok := trusted.(SafeCSS); !ok {
+			s = cssEscape(s)
+		}
+	}
+	_, err = out.WriteString(attrEscape(s, n.esc.delim, isHTML && n.esc.state == escAttr))
+	return err
+}
+
+// attrEscape escapes s for an attribute value ending with delim. Entities are
+// kept as is in trusted HTML.
+func attrEscape(s string, delim escDelim, keepEntities bool) string {
+	if delim == escDelimNone {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '&':
+			if keepEntities {
+				b.WriteByte(c)
+			} else {
+				b.WriteString("&amp;")
+			}
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"', '\'':
+			fmt.Fprintf(&b, "&#%d;", c)
+		case ' ', '\t', '\n', '\f', '\r', '=', '`':
+			if delim == escDelimSpace {
+				fmt.Fprintf(&b, "&#%d;", c)
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// attrNameFilter returns s if it can be used as attribute name
+func attrNameFilter(s string) string {
+	for i := 0; i < len(s); i++ {
+		if !isTagNameChar(s[i]) && s[i] != '_' && s[i] != '.' {
+			return "ZtplZ"
+		}
+	}
+	if escAttrTypeOf(s) != escAttrNormal {
+		// the type of the value could not be known when compiling
+		return "ZtplZ"
+	}
+	return s
+}
+
+// urlSchemes lists the URL schemes allowed at the start of a URL attribute
+var urlSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "tel": true}
+
+// urlFilter returns s if it is a relative URL or uses an allowed scheme
+func urlFilter(s string) string {
+	if i := strings.IndexAny(s, ":/?#"); i >= 0 && s[i] == ':' && !urlSchemes[strings.ToLower(s[:i])] {
+		return "#ZtplZ"
+	}
+	return s
+}
+
+// isURLUnreserved returns true for characters never encoded in URLs
+func isURLUnreserved(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// urlNormalize encodes the characters that cannot appear in a URL, keeping
+// existing %-encoding
+func urlNormalize(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isURLUnreserved(c) || strings.IndexByte("!#$%&'()*+,/:;=?@[]", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// urlQueryEscape encodes all reserved characters of s, keeping existing
+// %-encoding such as the output of urlencode()
+func urlQueryEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isURLUnreserved(c) || c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// jsValue returns v as a JavaScript expression
+func jsValue(v any) (string, error) {
+	switch rv := v.(type) {
+	case *bytes.Buffer:
+		v = rv.String()
+	case []byte:
+		v = string(rv)
+	case fmt.Stringer:
+		v = rv.String()
+	}
+	if s, ok := v.(string); ok {
+		return `"` + jsStringEscape(s) + `"`, nil
+	}
+	r, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if len(r) > 0 && r[0] == '-' {
+		// avoid creating -- after a -
+		return " " + string(r), nil
+	}
+	return string(r), nil
+}
+
+// jsStringEscape escapes s for any kind of JavaScript string, or comment
+func jsStringEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '/':
+			b.WriteString(`\/`)
+		case '"', '\'', '`', '<', '>', '&', '$', '\u2028', '\u2029', utf8.RuneError:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// cssEscape escapes s for CSS code or strings
+func cssEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r >= 0x80 && r != utf8.RuneError:
+			b.WriteRune(r)
+		case strings.ContainsRune(" #%.,-_", r):
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, `\%x `, r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,219 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestAutoEscape(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_x":    `<b>"it's" & co</b>`,
+		"_url":  "javascript:alert(1)",
+		"_link": "/a b?c",
+		"_q":    "a b&c=d",
+		"_n":    -3,
+		"_list": []any{"a", 1},
+		"_css":  "red;x:url(y)",
+		"_attr": "onclick",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"text", `<p>{{_x}}</p>`, `<p>&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;</p>`},
+		{"attribute", `<p title="{{_x}}">`, `<p title="&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;">`},
+		{"unquoted_attribute", `<p title={{_q}}>`, `<p title=a&#32;b&amp;c&#61;d>`},
+		{"number", `<p data-n="{{_n}}">{{_n}}</p>`, `<p data-n="-3">-3</p>`},
+		{"url_scheme", `<a href="{{_url}}">`, `<a href="#ZtplZ">`},
+		{"url_path", `<a href="{{_link}}">`, `<a href="/a%20b?c">`},
+		{"url_query", `<a href="/s?q={{_q}}&amp;r={{_q|urlencode()}}">`, `<a href="/s?q=a%20b%26c%3Dd&amp;r=a%2Bb%26c%3Dd">`},
+		{"script", `<script>var x = {{_x}}, n ={{_n}}, l = {{_list}};</script>`, `<script>var x = "\u003cb\u003e\u0022it\u0027s\u0022 \u0026 co\u003c\/b\u003e", n = -3, l = ["a",1];</script>`},
+		{"script_string", `<script>var s = 'a{{_q}}'; // it's {{_q}}` + "\n</script>{{_q}}", `<script>var s = 'aa b\u0026c=d'; // it's a b\u0026c=d` + "\n</script>a b&amp;c=d"},
+		{"event_attribute", `<a onclick="f({{_q}})">`, `<a onclick="f(&#34;a b\u0026c=d&#34;)">`},
+		{"style", `<style>p { color: {{_css}} }</style><p style="color: {{_css}}">`, `<style>p { color: red\3b x\3a url\28 y\29  }</style><p style="color: red\3b x\3a url\28 y\29 ">`},
+		{"attribute_name", `<div {{_attr}}="x" {{"class"}}="y">`, `<div ZtplZ="x" class="y">`},
+		{"comment", `<!-- {{_x}} -->`, `<!-- &lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt; -->`},
+		{"raw", `{{_x|raw()}}<p title="{{_x|raw()}}">`, `<b>"it's" & co</b><p title="&lt;b&gt;&#34;it&#39;s&#34; & co&lt;/b&gt;">`},
+		{"entities", `{{_x|entities()}}<p title="{{_x|entities()}}">`, `&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;<p title="&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;">`},
+		{"bbcode", `{{"[b]a[/b]"|bbcode()}}`, `<b>a</b>`},
+		{"template", `{{header}}{{include header}}`, `<h1>&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;</h1><h1>&lt;b&gt;&#34;it&#39;s&#34; &amp; co&lt;/b&gt;</h1>`},
+		{"control", `{{foreach _list as _v}}<i class="{{if _v == 1}}b{{else}}a{{/if}}">{{_v}}</i>{{/foreach}}`, `<i class="a">a</i><i class="b">1</i>`},
+		{"url_branches", `<a href="{{if _n}}?{{/if}}{{_q}}">`, `<a href="?a%20b%26c%3Dd">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.AutoEscape = true
+			engine.Raw.TemplateData["main"] = tt.template
+			engine.Raw.TemplateData["header"] = "<h1>{{_x}}</h1>"
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrustedFilterOutput(t *testing.T) {
+	// without auto-escaping, the output of entities, bbcode and markdown
+	// behaves as a string
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"compare", `{{if {{"a"|entities()}} == "a"}}yes{{else}}no{{/if}}`, "yes"},
+		{"compare_bbcode", `{{if "[b]a[/b]"|bbcode() == "<b>a</b>"}}yes{{else}}no{{/if}}`, "yes"},
+		{"length", `{{"a&b"|entities()|length()}}`, "7"},
+		{"reverse", `{{"abc"|entities()|reverse()}}`, "cba"},
+		{"type", `{{"abc"|entities()|type()}}`, "string"},
+		{"toint", `{{"3"|entities()|toint()}}`, "3"},
+		{"arrayslice", `{{"abc"|entities()|arrayslice(1)}}`, "a"},
+		{"less", `{{if "abc"|entities() < "b"}}yes{{else}}no{{/if}}`, "yes"},
+		{"in", `{{if "b" in "abc"|entities()}}yes{{else}}no{{/if}}`, "yes"},
+		{"add", `{{("3"|entities() + 1)}}`, "4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(context.Background(), "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAutoEscapeMode(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_x": "<b>"})
+
+	tests := []struct {
+		name       string
+		autoEscape bool
+		props      map[string]string
+		expected   string
+	}{
+		{"default", false, nil, "<b>"},
+		{"field", true, nil, "&lt;b&gt;"},
+		{"field_not_html", true, map[string]string{"Content-Type": "text/plain"}, "<b>"},
+		{"xhtml", true, map[string]string{"Content-Type": "application/xhtml+xml", "Charset": "UTF-8"}, "&lt;b&gt;"},
+		{"property_html", false, map[string]string{"AutoEscape": "html", "Content-Type": "text/plain"}, "&lt;b&gt;"},
+		{"property_auto", false, map[string]string{"AutoEscape": "auto"}, "&lt;b&gt;"},
+		{"property_none", true, map[string]string{"AutoEscape": "none"}, "<b>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.AutoEscape = tt.autoEscape
+			for k, v := range tt.props {
+				engine.Raw.PageProperties[k] = v
+			}
+			engine.Raw.TemplateData["main"] = "{{_x}}"
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	engine := tpl.New()
+	engine.Raw.PageProperties["AutoEscape"] = "yes"
+	engine.Raw.TemplateData["main"] = "{{_x}}"
+	if err := engine.Compile(ctx); err == nil || !strings.Contains(err.Error(), "invalid AutoEscape property") {
+		t.Errorf("got %v, want invalid property error", err)
+	}
+}
+
+func TestAutoEscapeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		line     int
+		char     int
+	}{
+		{"if", `<p class="{{if _a}}a{{else}}a">{{/if}}`, 1, 11},
+		{"if_without_else", "<p>\n{{if _a}}<b title=\"{{/if}}\">", 2, 1},
+		{"foreach", `{{foreach _a as _v}}<script>{{/foreach}}`, 1, 1},
+		{"switch", `{{switch _a}}{{case 1}}<a href="{{/switch}}">`, 1, 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.AutoEscape = true
+			engine.Raw.TemplateData["main"] = tt.template
+			err := engine.Compile(context.Background())
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %v", err)
+			}
+			if !strings.Contains(e.Message, "branches end in different contexts") {
+				t.Errorf("got message %q", e.Message)
+			}
+			if e.Line != tt.line || e.Char != tt.char {
+				t.Errorf("got error at %d:%d, want %d:%d (%s)", e.Line, e.Char, tt.line, tt.char, e)
+			}
+
+			// the same page compiles without escaping
+			engine.AutoEscape = false
+			if err := engine.Compile(context.Background()); err != nil {
+				t.Errorf("Compile without escaping failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAutoEscapeCache(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_x": `"<b>"`})
+	engine := tpl.New()
+	engine.AutoEscape = true
+	engine.Raw.TemplateData["main"] = `<a href="/?q={{_x}}" onclick="f({{_x}})">{{_x}}{{"<i>"|raw()}}</a>`
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	data, err := engine.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	loaded := &tpl.Page{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	expected, _ := engine.ParseAndReturn(ctx, "main")
+	result, err := loaded.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != expected {
+		t.Errorf("got %q, want %q", result, expected)
+	}
+}
@@ -0,0 +1,216 @@
+package tpl
+
+// exprParser builds the tree of an expression from the flat list of values
+// and operators returned by compileTpl_step2_tokens, using precedence
+// climbing. Operator weights come from math_operators, a lower weight binding
+// tighter.
+//
+// From lowest to highest priority:
+//
+//	list    = pair { "," pair }
+//	pair    = ternary [ ":" ternary ]
+//	ternary = binary [ "?" ternary ":" ternary ]
+//	binary  = unary { op binary }
+//	unary   = ( "!" | "~" | "-" ) unary | value
+type exprParser struct {
+	tokens internalArray
+	pos    int
+}
+
+// parseExpression returns the expression tree for tokens, as a single node
+func parseExpression(tokens internalArray) (internalArray, error) {
+	if len(tokens) == 0 {
+		return tokens, nil
+	}
+	p := &exprParser{tokens: tokens}
+	n, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, p.expected("operator", t)
+	}
+	return internalArray{n}, nil
+}
+
+// isOperatorToken returns true if n is an operator that has not been given
+// its operands yet
+func isOperatorToken(n *internalNode) bool {
+	return n.typ == internalOperator && len(n.sub) == 0
+}
+
+func (p *exprParser) peek() *internalNode {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return p.tokens[p.pos]
+}
+
+// peekOp returns the next token if it is the operator op
+func (p *exprParser) peekOp(op string) *internalNode {
+	t := p.peek()
+	if t == nil || !isOperatorToken(t) || t.str != op {
+		return nil
+	}
+	return t
+}
+
+// expected returns an error for token t (nil for the end of the expression)
+// found where what was expected
+func (p *exprParser) expected(what string, t *internalNode) error {
+	if t == nil {
+		return p.tokens[len(p.tokens)-1].error("expected %s, found end of expression", what)
+	}
+	return t.error("expected %s, found %s", what, describeToken(t))
+}
+
+// describeToken returns a short description of n for error messages
+func describeToken(n *internalNode) string {
+	switch n.typ {
+	case internalOperator:
+		if len(n.sub) == 0 {
+			return "operator " + n.str
+		}
+		return "expression"
+	case internalText:
+		return n.str
+	case internalQuote:
+		return "string"
+	case internalLink:
+		return "variable"
+	case internalFunc:
+		return "function @" + n.str
+	default:
+		return "value"
+	}
+}
+
+func (p *exprParser) parseList() (*internalNode, error) {
+	first, err := p.parsePair()
+	if err != nil {
+		return nil, err
+	}
+	list := p.peekOp(",")
+	if list == nil {
+		return first, nil
+	}
+	items := []internalArray{internalArray{first}}
+	for p.peekOp(",") != nil {
+		p.pos++
+		n, err := p.parsePair()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, internalArray{n})
+	}
+	// the first comma becomes the list
+	list.typ = internalList
+	list.str = ""
+	list.sub = items
+	return list, nil
+}
+
+// parsePair parses key: value pairs, used in map literals
+func (p *exprParser) parsePair() (*internalNode, error) {
+	key, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peekOp(":")
+	if op == nil {
+		return key, nil
+	}
+	p.pos++
+	val, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	op.sub = []internalArray{internalArray{key}, internalArray{val}}
+	return op, nil
+}
+
+// parseTernary parses cond ? a : b, which is right associative
+func (p *exprParser) parseTernary() (*internalNode, error) {
+	cond, err := p.parseBinary(math_operators["?"])
+	if err != nil {
+		return nil, err
+	}
+	op := p.peekOp("?")
+	if op == nil {
+		return cond, nil
+	}
+	p.pos++
+	a, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peekOp(":") == nil {
+		return nil, p.expected(":", p.peek())
+	}
+	p.pos++
+	b, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	op.sub = []internalArray{internalArray{cond}, internalArray{a}, internalArray{b}}
+	return op, nil
+}
+
+// parseBinary parses binary operators with a weight lower than limit. Operators
+// of the same weight are left associative.
+func (p *exprParser) parseBinary(limit int) (*internalNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op == nil || !isOperatorToken(op) || op.str == "!" || op.str == "~" {
+			return left, nil
+		}
+		w, ok := math_operators[op.str]
+		if !ok {
+			return nil, op.error("invalid operator %s", op.str)
+		}
+		if w >= limit {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseBinary(w)
+		if err != nil {
+			return nil, err
+		}
+		op.sub = []internalArray{internalArray{left}, internalArray{right}}
+		left = op
+	}
+}
+
+func (p *exprParser) parseUnary() (*internalNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, p.expected("value", nil)
+	}
+	p.pos++
+	if !isOperatorToken(t) {
+		return t, nil
+	}
+	switch t.str {
+	case "!", "~":
+		val, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		t.sub = []internalArray{internalArray{val}}
+	case "-":
+		// unary minus: convert to (0 - x)
+		val, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		zeroNode := t.e.makeValueNode(t.tpl, t.line, t.char, int64(0))
+		t.sub = []internalArray{internalArray{zeroNode}, internalArray{val}}
+	default:
+		return nil, p.expected("value", t)
+	}
+	return t, nil
+}
@@ -0,0 +1,109 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestExpressionParser(t *testing.T) {
+	vars := map[string]any{"_s": "hello", "_csv": "a,b,c", "_n": 3}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"precedence", `{{(1 + 2 * 3 - 4 / 2)}}`, "5"},
+		{"left_associative", `{{(10 - 2 - 3)}}`, "5"},
+		{"grouping", `{{((1 + 2) * 3)}}`, "9"},
+		{"unary_minus", `{{(-2 * 3)}}`, "-6"},
+		{"binary_unary_minus", `{{(2 - -3)}}`, "5"},
+		{"double_not", `{{(!!1)}}`, "1"},
+		{"not_comparison", `{{(!0 == 1)}}`, "1"},
+		{"ternary_right_associative", `{{(0 ? "a" : 0 ? "b" : "c")}}`, "c"},
+		{"ternary_nested_then", `{{(1 ? 0 ? "a" : "b" : "c")}}`, "b"},
+		{"list", `{{@seq(1, 1 + 2)|json()}}`, "[1,2,3]"},
+		{"function", `{{(@seq(1, 3)|json())}}`, "[1,2,3]"},
+		{"function_operand", `{{(1 in @seq(1, 3))}}`, "1"},
+		{"filter", `{{(_s|uppercase() .. "!")}}`, "HELLO!"},
+		{"filter_chain", `{{(_s|substr(0, 2)|uppercase())}}`, "HE"},
+		{"filter_binds_to_value", `{{("a" .. _s|uppercase())}}`, "aHELLO"},
+		{"filter_then_index", `{{(_csv|explode(",")[1])}}`, "b"},
+		{"filter_in_function_args", `{{@printf("%s-%s", _s|uppercase(), "x")}}`, "HELLO-x"},
+		{"filter_in_array", `{{[_s|uppercase(), 2]|json()}}`, `["HELLO",2]`},
+		{"filter_in_map", `{{{"k": _s|uppercase()}|json()}}`, `{"k":"HELLO"}`},
+		{"filter_in_index", `{{([10, 20, 30][_n|tostring()|length()])}}`, "20"},
+		{"filter_in_condition", `{{if (_s|length()) > 3}}long{{/if}}`, "long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		message  string
+		line     int
+		char     int
+	}{
+		{"missing_operand", `{{(1 + )}}`, "expected value, found end of expression", 1, 6},
+		{"two_operators", `{{(1 + * 2)}}`, "expected value, found operator *", 1, 8},
+		{"two_values", `{{(1 2)}}`, "expected operator, found 2", 1, 6},
+		{"ternary_without_else", `{{(1 ? 2)}}`, "expected :, found end of expression", 1, 8},
+		{"leading_comma", `{{(, 1)}}`, "expected value, found operator ,", 1, 4},
+		{"trailing_comma", `{{(1,)}}`, "expected value, found end of expression", 1, 5},
+		{"multiline", "{{(1 +\n  * 2)}}", "expected value, found operator *", 2, 3},
+		{"direct_output", `{{1 + * 2}}`, "expected value, found operator *", 1, 7},
+		{"in_if", `{{if 1 + * 2}}x{{/if}}`, "expected value, found operator *", 1, 10},
+		{"after_string", `{{"a" .. * 2}}`, "expected value, found operator *", 1, 10},
+		{"unhandled_char", `{{(1 $ 2)}}`, "unhandled char $", 1, 6},
+		{"function_without_args", `{{(@seq + 1)}}`, "expected @function(...) call", 1, 4},
+		{"function_at_end", `{{(1 + @seq)}}`, "expected ( after @seq, found end of expression", 1, 8},
+		{"filter_without_value", `{{(1 + |uppercase())}}`, "expected value before filter", 1, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			err := engine.Compile(context.Background())
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %T", err)
+			}
+			if !strings.Contains(e.Message, tt.message) {
+				t.Errorf("got message %q, want %q", e.Message, tt.message)
+			}
+			if e.Line != tt.line || e.Char != tt.char {
+				t.Errorf("got error at %d:%d, want %d:%d (%s)", e.Line, e.Char, tt.line, tt.char, e)
+			}
+		})
+	}
+}
@@ -36,6 +36,7 @@ func init() {
 	RegisterFilter("jsonparse", fltJsonParse)
 	RegisterFilter("nl2br", fltNl2br)
 	RegisterFilter("entities", fltEntities)
+	RegisterFilter("raw", fltRaw)
 	RegisterFilter("stripcrlf", fltStripcrlf)
 	// ascii
 	RegisterFilter("nbsp", fltNbsp)
@@ -194,6 +195,8 @@ func fltJson(ctx context.Context, params Values, in Value, out WritableValue) er
 		inB = b.String()
 	case []byte:
 		inB = string(b)
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		inB, _ = safeString(b)
 	}
 
 	r, err := json.Marshal(inB)
@@ -237,7 +240,15 @@ func fltJsonParse(ctx context.Context, params Values, in Value, out WritableValu
 }
 
 func fltEntities(ctx context.Context, params Values, in Value, out WritableValue) error {
-	return out.WriteValue(ctx, html.EscapeString(in.WithCtx(ctx).String()))
+	return out.WriteValue(ctx, SafeHTML(html.EscapeString(in.WithCtx(ctx).String())))
+}
+
+func fltRaw(ctx context.Context, params Values, in Value, out WritableValue) error {
+	str, err := in.WithCtx(ctx).StringErr()
+	if err != nil {
+		return err
+	}
+	return out.WriteValue(ctx, SafeHTML(str))
 }
 
 func fltStripcrlf(ctx context.Context, params Values, in Value, out WritableValue) error {
@@ -322,6 +333,12 @@ func fltImplode(ctx context.Context, params Values, in Value, out WritableValue)
 		return out.WriteValue(ctx, strings.Join(val, params[0].WithCtx(ctx).String()))
 	case [][]byte:
 		return out.WriteValue(ctx, bytes.Join(val, params[0].WithCtx(ctx).Bytes()))
+	case *orderedMap:
+		strs := make([]string, len(val.values))
+		for i, v := range val.values {
+			strs[i] = NewValue(v).WithCtx(ctx).String()
+		}
+		return out.WriteValue(ctx, strings.Join(strs, params[0].WithCtx(ctx).String()))
 	default:
 		return out.WriteValue(ctx, val)
 	}
@@ -472,6 +489,9 @@ func fltLength(ctx context.Context, params Values, in Value, out WritableValue)
 		return out.WriteValue(ctx, len(i))
 	case string:
 		return out.WriteValue(ctx, len(i))
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		str, _ := safeString(i)
+		return out.WriteValue(ctx, len(str))
 	case *bytes.Buffer:
 		return out.WriteValue(ctx, i.Len())
 	case []interface{}:
@@ -486,6 +506,8 @@ func fltLength(ctx context.Context, params Values, in Value, out WritableValue)
 		return out.WriteValue(ctx, len(i))
 	case map[string]json.RawMessage:
 		return out.WriteValue(ctx, len(i))
+	case interface{ Size() int }:
+		return out.WriteValue(ctx, i.Size())
 	default:
 		return out.WriteValue(ctx, len(in.WithCtx(ctx).String()))
 	}
@@ -536,6 +558,8 @@ func fltReverse(ctx context.Context, params Values, in Value, out WritableValue)
 		}
 
 		return out.WriteValue(ctx, r)
+	case *orderedMap:
+		return out.WriteValue(ctx, i.reverse())
 	default:
 		return fmt.Errorf("reverse() filter argument should be an array or a string, type %T not supported", inObj)
 	}
@@ -709,6 +733,11 @@ func fltArraySlice(ctx context.Context, params Values, in Value, out WritableVal
 			return out.WriteValue(ctx, i[from:])
 		}
 		return out.WriteValue(ctx, i[from:from+to])
+	case *orderedMap:
+		if from >= int64(i.Size()) {
+			return nil
+		}
+		return out.WriteValue(ctx, i.slice(int(from), int(min(from+to, int64(i.Size())))))
 	default:
 		return fmt.Errorf("arraySlice() filter argument should be an array or a string, type %T not supported", inObj)
 	}
@@ -774,7 +803,7 @@ func fltArrayFilter(ctx context.Context, params Values, in Value, out WritableVa
 }
 
 func fltBbCode(ctx context.Context, params Values, in Value, out WritableValue) error {
-	return out.WriteValue(ctx, BBCodeCompiler.Compile(in.WithCtx(ctx).String()))
+	return out.WriteValue(ctx, SafeHTML(BBCodeCompiler.Compile(in.WithCtx(ctx).String())))
 }
 
 func fltStripBbCode(ctx context.Context, params Values, in Value, out WritableValue) error {
@@ -802,5 +831,5 @@ func fltStripBbCode(ctx context.Context, params Values, in Value, out WritableVa
 func fltMarkdown(ctx context.Context, params Values, in Value, out WritableValue) error {
 	res := blackfriday.Run([]byte(in.WithCtx(ctx).String()))
 	final := bluemonday.UGCPolicy().SanitizeBytes(res)
-	return out.WriteValue(ctx, final)
+	return out.WriteValue(ctx, SafeHTML(final))
 }
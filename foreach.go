@@ -1,14 +1,26 @@
 package tpl
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 )
 
 func foreachAny(ctx context.Context, val interface{}, elementF func(k, v interface{}, idx, max int64) error) (int64, error) {
 	idx := int64(0)
 
+	// stop between elements if the context is canceled
+	fn := elementF
+	elementF = func(k, v interface{}, idx, max int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(k, v, idx, max)
+	}
+
 	switch valT := val.(type) {
 	case Values:
 		max := int64(len(valT))
@@ -38,44 +50,15 @@ func foreachAny(ctx context.Context, val interface{}, elementF func(k, v interfa
 		}
 		return idx, nil
 	case map[string]Value:
-		max := int64(len(valT))
-		for kT, vT := range valT {
-			idx += 1
-			if err := elementF(kT, vT, idx, max); err != nil {
-				return idx, err
-			}
-		}
-		return idx, nil
+		return foreachMap(valT, elementF)
 	case map[string]interface{}:
-		max := int64(len(valT))
-		for kT, vT := range valT {
-			idx += 1
-			if err := elementF(kT, vT, idx, max); err != nil {
-				return idx, err
-			}
-		}
-		return idx, nil
+		return foreachMap(valT, elementF)
 	case map[string]json.RawMessage:
-		max := int64(len(valT))
-		for kT, vT := range valT {
-			idx += 1
-			if err := elementF(kT, vT, idx, max); err != nil {
-				return idx, err
-			}
-		}
-		return idx, nil
+		return foreachMap(valT, elementF)
 	case json.RawMessage:
-		var d interface{}
-		if err := json.Unmarshal(valT, &d); err != nil {
-			return 0, err
-		}
-		return foreachAny(ctx, d, elementF)
+		return foreachJSON(ctx, valT, elementF)
 	case interface{ RawJSONBytes() []byte }:
-		var d any
-		if err := json.Unmarshal(valT.RawJSONBytes(), &d); err != nil {
-			return 0, err
-		}
-		return foreachAny(ctx, d, elementF)
+		return foreachJSON(ctx, valT.RawJSONBytes(), elementF)
 	case ValueReader:
 		v, err := valT.ReadValue(ctx)
 		if err != nil {
@@ -121,3 +104,70 @@ func foreachAny(ctx context.Context, val interface{}, elementF func(k, v interfa
 		return 0, fmt.Errorf("unsupported type for foreach: %T", val)
 	}
 }
+
+// foreachMap iterates over a map in key order, so the output is the same
+// each time
+func foreachMap[V any](m map[string]V, elementF func(k, v interface{}, idx, max int64) error) (int64, error) {
+	idx := int64(0)
+	max := int64(len(m))
+	for _, kT := range slices.Sorted(maps.Keys(m)) {
+		idx += 1
+		if err := elementF(kT, m[kT], idx, max); err != nil {
+			return idx, err
+		}
+	}
+	return idx, nil
+}
+
+// foreachJSON iterates over JSON data. Objects are iterated in the order
+// their keys appear in the source.
+func foreachJSON(ctx context.Context, data []byte, elementF func(k, v interface{}, idx, max int64) error) (int64, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, err
+	}
+	if tok != json.Delim('{') {
+		var d any
+		if err := json.Unmarshal(data, &d); err != nil {
+			return 0, err
+		}
+		return foreachAny(ctx, d, elementF)
+	}
+
+	var keys []string
+	var values []any
+	pos := make(map[string]int)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, err
+		}
+		k, _ := tok.(string)
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return 0, err
+		}
+		if p, ok := pos[k]; ok {
+			// duplicate key, last value wins like json.Unmarshal
+			values[p] = v
+			continue
+		}
+		pos[k] = len(keys)
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return 0, err
+	}
+
+	idx := int64(0)
+	max := int64(len(keys))
+	for i, kT := range keys {
+		idx += 1
+		if err := elementF(kT, values[i], idx, max); err != nil {
+			return idx, err
+		}
+	}
+	return idx, nil
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/KarpelesLab/tpl"
+	"golang.org/x/text/language"
 )
 
 func TestForeach(t *testing.T) {
@@ -36,8 +37,8 @@ func TestForeach(t *testing.T) {
 		{
 			"map",
 			`{{foreach {{_map}} as _item}}{{_item_key}}={{_item}};{{/foreach}}`,
-			map[string]any{"_map": map[string]any{"x": 1, "y": 2}},
-			"",
+			map[string]any{"_map": map[string]any{"y": 2, "x": 1}},
+			"x=1;y=2;",
 		},
 		{
 			"empty_with_else",
@@ -78,11 +79,6 @@ func TestForeach(t *testing.T) {
 				t.Fatalf("ParseAndReturn failed: %v", err)
 			}
 
-			// For map iteration, order is not guaranteed, so skip strict comparison
-			if tt.name == "map" {
-				return
-			}
-
 			if result != tt.expected {
 				t.Errorf("got %q, want %q", result, tt.expected)
 			}
@@ -291,3 +287,190 @@ func TestForeachWithMapAny(t *testing.T) {
 		t.Errorf("got %q, want %q", result, "x=1;")
 	}
 }
+
+func TestForeachSyntax(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"key_value", `{{foreach {{_arr}} as _k => _v}}{{_k}}={{_v}},{{/foreach}}`, "0=a,1=b,2=c,"},
+		{"key_value_no_spaces", `{{foreach {{_arr}} as _k=>_v}}{{_k}}={{_v}},{{/foreach}}`, "0=a,1=b,2=c,"},
+		{"key_value_meta", `{{foreach {{_arr}} as _k => _v}}{{_v_key}}{{_v_idx}},{{/foreach}}`, "01,12,23,"},
+		{"bare_variable", `{{foreach _arr as _v}}{{_v}}{{/foreach}}`, "abc"},
+		{"bare_key_value", `{{foreach _arr as _k => _v}}{{_k}}{{_v}}{{/foreach}}`, "0a1b2c"},
+		{"bare_path", `{{foreach _obj/list as _v}}{{_v}}{{/foreach}}`, "xy"},
+		{"bare_bracket", `{{foreach _obj["list"] as _v}}{{_v}}{{/foreach}}`, "xy"},
+		{"bare_filter", `{{foreach _arr|reverse() as _v}}{{_v}}{{/foreach}}`, "cba"},
+		{"braces_filter", `{{foreach {{_arr}}|reverse() as _v}}{{_v}}{{/foreach}}`, "cba"},
+		{"braces_inner_filter", `{{foreach {{_arr|reverse()}} as _v}}{{_v}}{{/foreach}}`, "cba"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+				"_arr": []string{"a", "b", "c"},
+				"_obj": map[string]any{"list": []any{"x", "y"}},
+			})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForeachSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"no_as", `{{foreach {{_arr}}}}x{{/foreach}}`},
+		{"no_var", `{{foreach {{_arr}} as }}x{{/foreach}}`},
+		{"bad_var", `{{foreach {{_arr}} as v}}x{{/foreach}}`},
+		{"bad_key", `{{foreach {{_arr}} as k => _v}}x{{/foreach}}`},
+		{"same_key_value", `{{foreach {{_arr}} as _v => _v}}x{{/foreach}}`},
+		{"two_vars", `{{foreach {{_arr}} as _a _b}}x{{/foreach}}`},
+		{"bad_source", `{{foreach arr as _v}}x{{/foreach}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
+
+func TestForeachMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"first", `{{foreach {{_arr}} as _v}}{{if {{_v_first}}}}[{{/if}}{{_v}}{{/foreach}}`, "[abc"},
+		{"last", `{{foreach {{_arr}} as _v}}{{_v}}{{if {{_v_last}}}}]{{else}},{{/if}}{{/foreach}}`, "a,b,c]"},
+		{"odd_even", `{{foreach {{_arr}} as _v}}{{if {{_v_odd}}}}o{{/if}}{{if {{_v_even}}}}e{{/if}}{{/foreach}}`, "oeo"},
+		{"next", `{{foreach {{_arr}} as _v}}{{_v}}>{{_v_nxt}};{{/foreach}}`, "a>b;b>c;c>;"},
+		{"prev", `{{foreach {{_arr}} as _v}}{{_v_prv}}<{{_v}};{{/foreach}}`, "<a;a<b;b<c;"},
+		{"depth", `{{foreach {{_arr}} as _a}}{{_a_depth}}{{foreach {{_arr}} as _b}}{{_b_depth}}{{/foreach}};{{/foreach}}`, "1222;1222;1222;"},
+		{"single", `{{foreach {{_one}} as _v}}{{if {{_v_first}} && {{_v_last}}}}only{{/if}}{{/foreach}}`, "only"},
+		{"last_with_break", `{{foreach {{_arr}} as _v}}{{_v}}{{break if {{_v}} == "b"}}{{if {{_v_last}}}}!{{/if}}{{/foreach}}`, "ab"},
+		{"next_with_continue", `{{foreach {{_arr}} as _v}}{{continue if {{_v}} == "a"}}{{_v}}{{_v_nxt}}{{/foreach}}`, "bcc"},
+		{"map_last", `{{foreach {{_map}} as _k => _v}}{{_k}}{{if {{_v_last}}}}.{{/if}}{{/foreach}}`, "x."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+				"_arr": []string{"a", "b", "c"},
+				"_one": []string{"a"},
+				"_map": map[string]any{"x": 1},
+			})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForeachOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"map_sorted", `{{foreach _map as _k => _v}}{{_k}}{{_v}},{{/foreach}}`, "a1,b10,c2,item10x,item2y,"},
+		{"map_value_sorted", `{{foreach _mapv as _k => _v}}{{_k}}{{_v}},{{/foreach}}`, "a1,b2,"},
+		{"json_source_order", `{{foreach _json as _k => _v}}{{_k}}{{_v}},{{/foreach}}`, "z1,a2,m3,"},
+		{"json_duplicate_key", `{{foreach _jsondup as _k => _v}}{{_k}}{{_v}},{{/foreach}}`, "b1,a3,"},
+		{"json_array", `{{foreach _jsonarr as _v}}{{_v}},{{/foreach}}`, "3,1,2,"},
+		{"sortkeys", `{{foreach _map|sortkeys() as _k => _v}}{{_k}},{{/foreach}}`, "a,b,c,item10,item2,"},
+		{"sortkeys_desc", `{{foreach _map|sortkeys("desc") as _k => _v}}{{_k}},{{/foreach}}`, "item2,item10,c,b,a,"},
+		{"sortkeys_natural", `{{foreach _map|sortkeys("natural") as _k => _v}}{{_k}},{{/foreach}}`, "a,b,c,item2,item10,"},
+		{"sortkeys_json", `{{foreach _json|sortkeys() as _k => _v}}{{_k}},{{/foreach}}`, "a,m,z,"},
+		{"sortvalues", `{{foreach _map|sortvalues() as _k => _v}}{{_v}},{{/foreach}}`, "1,2,10,x,y,"},
+		{"sortvalues_desc", `{{foreach _map|sortvalues("desc") as _k => _v}}{{_v}},{{/foreach}}`, "y,x,10,2,1,"},
+		{"sortvalues_slice", `{{foreach _list|sortvalues() as _k => _v}}{{_k}}{{_v}},{{/foreach}}`, "2Banana,1apple,0cherry,"},
+		{"sortvalues_strings", `{{foreach _files|sortvalues() as _v}}{{_v}},{{/foreach}}`, "file1,file10,file2,"},
+		{"sortvalues_natural", `{{foreach _files|sortvalues("natural") as _v}}{{_v}},{{/foreach}}`, "file1,file2,file10,"},
+		{"sortvalues_natural_desc", `{{foreach _files|sortvalues("natural", "desc") as _v}}{{_v}},{{/foreach}}`, "file10,file2,file1,"},
+		{"sortvalues_locale", `{{foreach _accents|sortvalues("locale") as _v}}{{_v}},{{/foreach}}`, "apple,Banana,éclair,zebra,"},
+		{"sortvalues_plain", `{{foreach _accents|sortvalues() as _v}}{{_v}},{{/foreach}}`, "Banana,apple,zebra,éclair,"},
+		{"sorted_meta", `{{foreach _map|sortkeys() as _v}}{{_v_idx}}/{{_v_max}}{{if {{_v_last}}}}.{{/if}} {{/foreach}}`, "1/5 2/5 3/5 4/5 5/5. "},
+		{"sorted_length", `{{_map|sortkeys()|length()}}`, "5"},
+		{"sorted_json", `{{_map|sortvalues("desc")|json()}}`, `{"item2":"y","item10":"x","b":10,"c":2,"a":1}`},
+		{"sorted_json_list", `{{_list|sortvalues()|json()}}`, `["Banana","apple","cherry"]`},
+		{"sorted_implode", `{{_files|sortvalues("natural")|implode(",")}}`, "file1,file2,file10"},
+		{"sorted_string", `{{_map|sortkeys()}} {{_list|sortvalues()}}`, "map[a:1 b:10 c:2 item10:x item2:y] [Banana apple cherry]"},
+		{"sorted_reverse", `{{_map|sortkeys()|reverse()|json()}}`, `{"item2":"y","item10":"x","c":2,"b":10,"a":1}`},
+		{"sorted_arrayslice", `{{_files|sortvalues("natural")|arrayslice(1, 5)|implode(",")}}`, "file2,file10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+				"_map":      map[string]any{"c": 2, "a": 1, "b": 10, "item2": "y", "item10": "x"},
+				"_mapv":     map[string]tpl.Value{"b": tpl.NewValue(2), "a": tpl.NewValue(1)},
+				"_json":     json.RawMessage(`{"z": 1, "a": 2, "m": 3}`),
+				"_jsondup":  json.RawMessage(`{"b": 1, "a": 2, "a": 3}`),
+				"_jsonarr":  json.RawMessage(`[3, 1, 2]`),
+				"_list":     []string{"cherry", "apple", "Banana"},
+				"_files":    []string{"file10", "file2", "file1"},
+				"_accents":  []string{"zebra", "éclair", "Banana", "apple"},
+				"_language": language.English,
+			})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortFilterInvalidOption(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{foreach {{_list|sortvalues("sideways")}} as _v}}{{_v}}{{/foreach}}`
+
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_list": []string{"a"}})
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, err := engine.ParseAndReturn(ctx, "main"); err == nil {
+		t.Errorf("expected error for invalid sort option")
+	}
+}
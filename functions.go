@@ -43,7 +43,7 @@ func CallFunction(ctx context.Context, funcName string, params Values, target Wr
 	if f, ok := ctx.Value("@" + funcName).(TplFuncCallback); ok {
 		// custom in-context function
 		return f(ctx, params, target)
-	} else if f, ok := tplFunctions[funcName]; ok {
+	} else if f, ok := defaultRegistry.Function(funcName); ok {
 		// only call if ok
 		return f.Method(ctx, params, target)
 	} else {
@@ -152,6 +152,9 @@ func fncSeq(ctx context.Context, params Values, out WritableValue) error {
 	}
 
 	count := (end-start)/step + 1
+	if lim := limiterOf(ctx); lim != nil && lim.MaxIterations > 0 && count > lim.MaxIterations {
+		return fmt.Errorf("seq() of %d values: %w", count, ErrMaxIterations)
+	}
 	res := make(Values, count)
 
 	idx := 0
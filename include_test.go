@@ -0,0 +1,185 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestInclude(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		expected  string
+	}{
+		{
+			"no_params",
+			map[string]string{
+				"main":   `[{{include header}}]`,
+				"header": `Header`,
+			},
+			"[Header]",
+		},
+		{
+			"params",
+			map[string]string{
+				"main": `{{include card _title="Shoes" _price=(10 + 5)}}`,
+				"card": `{{params _title _price=0}}{{_title}}: {{_price}}`,
+			},
+			"Shoes: 15",
+		},
+		{
+			"default_value",
+			map[string]string{
+				"main": `{{include card _title="Hat"}}`,
+				"card": `{{params _title _price=0 _currency="EUR"}}{{_title}}: {{_price}} {{_currency}}`,
+			},
+			"Hat: 0 EUR",
+		},
+		{
+			"inline_values",
+			map[string]string{
+				"main": `{{include card _a=(1) _b=2}}`,
+				"card": `{{params _a _b}}{{_a}}+{{_b}}`,
+			},
+			"1+2",
+		},
+		{
+			"unquoted_values",
+			map[string]string{
+				"main": `{{include card _title=1 _price=2 _qty=3}}`,
+				"card": `{{params _title _price _qty}}{{_title}}: {{_price}} x{{_qty}}`,
+			},
+			"1: 2 x3",
+		},
+		{
+			"unquoted_variables",
+			map[string]string{
+				"main": `{{include card _title=_name _price=2}}`,
+				"card": `{{params _title _price}}{{_title}}: {{_price}}`,
+			},
+			"World: 2",
+		},
+		{
+			"mixed_values",
+			map[string]string{
+				"main": `{{include card _a="x" _b=_name _c=(1 + 2) _d=4}}`,
+				"card": `{{params _a _b _c _d}}{{_a}} {{_b}} {{_c}} {{_d}}`,
+			},
+			"x World 3 4",
+		},
+		{
+			"expression_value",
+			map[string]string{
+				"main": `{{include card _a=_num + 1 _b=_num == 2}}`,
+				"card": `{{params _a _b}}{{_a}} {{if _b}}yes{{/if}}`,
+			},
+			"3 yes",
+		},
+		{
+			"variable_value",
+			map[string]string{
+				"main": `{{include card _title={{_name}}}}`,
+				"card": `{{params _title}}Hello {{_title}}`,
+			},
+			"Hello World",
+		},
+		{
+			"undeclared_params",
+			map[string]string{
+				"main": `{{include card _x="a"}}`,
+				"card": `x={{_x}}`,
+			},
+			"x=a",
+		},
+		{
+			"include_with_filter",
+			map[string]string{
+				"main": `{{include card _x="a"|uppercase()}}`,
+				"card": `x={{_x}}`,
+			},
+			"X=A",
+		},
+		{
+			"params_with_set",
+			map[string]string{
+				"main": `{{set _title="Set"}}{{card}}{{/set}}`,
+				"card": "{{params _title _n=3}}\n{{_title}} {{_n}}",
+			},
+			"\nSet 3",
+		},
+		{
+			"params_scope",
+			map[string]string{
+				"main": `{{include card _title="a"}}/{{_title}}`,
+				"card": `{{params _title}}{{_title}}`,
+			},
+			"a/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			for k, v := range tt.templates {
+				engine.Raw.TemplateData[k] = v
+			}
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_name": "World", "_num": 2})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+	}{
+		{"unknown_template", map[string]string{"main": `{{include nothere}}`}},
+		{"unknown_param", map[string]string{"main": `{{include card _x=(1) _y=2}}`, "card": `{{params _x}}`}},
+		{"missing_param", map[string]string{"main": `{{include card _x=1}}`, "card": `{{params _x _y}}`}},
+		{"param_twice", map[string]string{"main": `{{include card _x=(1) _x=2}}`, "card": `{{params _x}}`}},
+		{"declared_twice", map[string]string{"main": `{{params _x _x}}`}},
+		{"params_twice", map[string]string{"main": `{{params _x}}{{params _y}}`}},
+		{"params_in_if", map[string]string{"main": `{{if 1}}{{params _x}}{{/if}}`}},
+		{"params_bad_name", map[string]string{"main": `{{params x}}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			for k, v := range tt.templates {
+				engine.Raw.TemplateData[k] = v
+			}
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
+
+func TestParamsRequiredAtRuntime(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{card}}`
+	engine.Raw.TemplateData["card"] = `{{params _title}}{{_title}}`
+
+	ctx := context.Background()
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, err := engine.ParseAndReturn(ctx, "main"); err == nil {
+		t.Errorf("expected error for missing required parameter")
+	}
+}
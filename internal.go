@@ -7,6 +7,8 @@ import (
 
 //go:generate stringer -output stringer.go -type=internalType
 
+// internalType values are stored in binary caches: add new types at the end,
+// and change cacheVersion if existing values change.
 const (
 	// internalInvalid nodes are invalid (problem during compilation?)
 	internalInvalid internalType = iota
@@ -17,7 +19,7 @@ const (
 	internalValue    // Value passed in value
 	internalIf       // If (Sub[0]) Sub[1] else Sub[2]
 	internalTry      // Try Sub[0] Catch(Str) Sub[1]
-	internalForeach  // foreach(Sub[0] as Str) Sub[1] else(if empty) Sub[2]
+	internalForeach  // foreach(Sub[0] as [Key =>] Str) Sub[1] else(if empty) Sub[2]
 	internalJs       // Str
 	internalFunc     // Str(Sub[0])
 	internalFilter   // Str(Sub[0]) <- (input)
@@ -27,15 +29,43 @@ const (
 	internalList     // Sub[*] (for example when values are separated by commas), parsed as Values
 	internalSet      // Sub[0] + filters (to set variables)
 	internalIndex    // Sub[0][Sub[1]] - bracket index access, Sub[0]=base, Sub[1]=index expression
+	internalBlock    // block "Str" with default content Sub[0], can be overridden by templates extending this one
+	internalExtends  // extends template "Str", Sub[*] each contain one internalBlock overriding the parent's
+	internalParent   // renders the parent version of the current block
+	internalInclude  // include template "Str" with parameters set in filters
+	internalParams   // declares parameters Sub[1] (internalVar, Sub[0] is the default if any) for the template Sub[0]
+	internalSwitch   // switch(Sub[0]) Sub[1] contains internalCase, default Sub[2]
+	internalCase     // case Sub[0] (list of values): Sub[1]
+	internalBreak    // break out of the current foreach, if Sub[0] (optional) is true
+	internalContinue // continue with the next item of the current foreach, if Sub[0] (optional) is true
+	internalArrayLit // [Sub[0], Sub[1], ...] array literal
+	internalMapLit   // {Sub[0]: Sub[1], Sub[2]: Sub[3], ...} map literal
+	internalSlice    // Sub[0][Sub[1]:Sub[2]] - slice access, Sub[1] and Sub[2] may be empty
+)
+
+// internalCtxKey is used for context values kept by the engine itself
+type internalCtxKey int
+
+const (
+	ctxBlocks       internalCtxKey = iota // map[string][]internalArray of block overrides, most derived first
+	ctxBlockParent                        // []internalArray of parent versions of the currently running block
+	ctxForeachDepth                       // int64 depth of the currently running foreach
+	ctxLenient                            // bool set when undefined values are allowed even in strict mode, such as left of ??
+	ctxLimits                             // Limits set by WithLimits
+	ctxLimiter                            // *limiter of the running template
+	ctxDepth                              // int nesting of the running template
 )
 
 // internalNode contains a sub-element in a given page
 type internalNode struct {
 	typ        internalType
 	str        string          // if any text data, or name of var for foreach, catch
+	key        string          // name of key var for foreach, if any
 	sub        []internalArray // eg. Sub[0]=Expr Sub[1]=Sub Sub[2]=Else
 	filters    internalArray   // an array of TPL_FILTER
 	value      Value
+	esc        escContext // HTML context of the output, if escaped
+	output     bool       // written to the output of the template, see markOutput
 	line, char int
 	tpl        string
 	e          *Page
@@ -43,6 +73,24 @@ type internalNode struct {
 
 type internalArray []*internalNode
 
+// walk calls f for each node in the array, including sub nodes and filters
+func (a internalArray) walk(f func(n *internalNode) error) error {
+	for _, n := range a {
+		if err := f(n); err != nil {
+			return err
+		}
+		for _, sub := range n.sub {
+			if err := sub.walk(f); err != nil {
+				return err
+			}
+		}
+		if err := n.filters.walk(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Error returns a template error suitable for being returned or for panic
 func (n *internalNode) error(msg string, arg ...interface{}) error {
 	return &Error{Message: fmt.Sprintf(msg, arg...), Template: n.tpl, Line: n.line, Char: n.char, Stack: debug.Stack()}
@@ -55,14 +103,3 @@ func (n *internalNode) subError(sub error, msg string, arg ...interface{}) error
 
 // internalType represents the type of a given internal template entry
 type internalType int
-
-var tplFunctions = map[string]*TplFunction{}
-var tplfilters = map[string]TplFiltCallback{}
-
-func RegisterFunction(name string, f *TplFunction) {
-	tplFunctions[name] = f
-}
-
-func RegisterFilter(name string, f TplFiltCallback) {
-	tplfilters[name] = f
-}
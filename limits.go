@@ -0,0 +1,149 @@
+package tpl
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// DefaultMaxDepth is the maximum nesting of templates when Limits.MaxDepth
+// is not set, so a template including itself fails instead of overflowing
+// the stack.
+const DefaultMaxDepth = 256
+
+// Errors returned, wrapped in an *Error, when a limit is reached. They are
+// not caught by {{try}}.
+var (
+	ErrMaxDepth      = errors.New("tpl: maximum template depth exceeded")
+	ErrMaxIterations = errors.New("tpl: maximum loop iterations exceeded")
+	ErrMaxOutput     = errors.New("tpl: maximum output size exceeded")
+	ErrMaxSteps      = errors.New("tpl: maximum evaluation steps exceeded")
+)
+
+// Limits restricts the resources used to run a template, such as one
+// written by users. Limits apply to each call to Parse, ParseAndWrite or
+// ParseAndReturn. Zero values mean no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting of templates through links, include
+	// and extends. If 0, DefaultMaxDepth is used.
+	MaxDepth int
+	// MaxIterations is the total number of foreach iterations. A @seq()
+	// larger than this fails right away.
+	MaxIterations int64
+	// MaxOutput is the number of bytes of text and values output. Values
+	// such as lists are not counted.
+	MaxOutput int64
+	// MaxSteps is the number of nodes evaluated, including the ones in
+	// expressions.
+	MaxSteps int64
+}
+
+// WithLimits returns a context in which templates run with limits l
+// instead of the ones of the page.
+func WithLimits(ctx context.Context, l Limits) context.Context {
+	return context.WithValue(ctx, ctxLimits, l)
+}
+
+// limiter counts the resources used by a run of a template
+type limiter struct {
+	Limits
+	iterations, output, steps atomic.Int64
+}
+
+// withLimiter returns ctx with a limiter for the limits applying to e, if
+// any and if ctx does not already have one
+func (e *Page) withLimiter(ctx context.Context) context.Context {
+	if ctx.Value(ctxLimiter) != nil {
+		return ctx
+	}
+	l, ok := ctx.Value(ctxLimits).(Limits)
+	if !ok {
+		l = e.Limits
+	}
+	if l == (Limits{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxLimiter, &limiter{Limits: l})
+}
+
+// limiterOf returns the limiter of ctx, or nil if there are no limits
+func limiterOf(ctx context.Context) *limiter {
+	lim, _ := ctx.Value(ctxLimiter).(*limiter)
+	return lim
+}
+
+// isLimit returns true if err was caused by a limit being reached
+func isLimit(err error) bool {
+	return errors.Is(err, ErrMaxDepth) || errors.Is(err, ErrMaxIterations) || errors.Is(err, ErrMaxOutput) || errors.Is(err, ErrMaxSteps)
+}
+
+// enter returns the context to run a template from n, one level deeper
+func (n *internalNode) enter(ctx context.Context) (context.Context, error) {
+	max := DefaultMaxDepth
+	if lim := limiterOf(ctx); lim != nil && lim.MaxDepth > 0 {
+		max = lim.MaxDepth
+	}
+	depth, _ := ctx.Value(ctxDepth).(int)
+	if depth >= max {
+		return nil, n.subError(ErrMaxDepth, "maximum template depth of %d exceeded", max)
+	}
+	return context.WithValue(ctx, ctxDepth, depth+1), nil
+}
+
+// nestedTpl is a template linked from another one, with its depth. The depth
+// is kept in the value since it can be read with the context of the output it
+// was written to, which is one level less deep.
+type nestedTpl struct {
+	internalArray
+	depth int
+}
+
+func (t nestedTpl) WithCtx(ctx context.Context) *ValueCtx {
+	return &ValueCtx{t, ctx}
+}
+
+func (t nestedTpl) ReadValue(ctx context.Context) (any, error) {
+	if depth, _ := ctx.Value(ctxDepth).(int); depth < t.depth {
+		ctx = context.WithValue(ctx, ctxDepth, t.depth)
+	}
+	return t.internalArray.ReadValue(ctx)
+}
+
+func (lim *limiter) step(n *internalNode) error {
+	if lim.MaxSteps > 0 && lim.steps.Add(1) > lim.MaxSteps {
+		return n.subError(ErrMaxSteps, "maximum of %d evaluation steps exceeded", lim.MaxSteps)
+	}
+	return nil
+}
+
+func (lim *limiter) iterate(n *internalNode) error {
+	if lim.MaxIterations > 0 && lim.iterations.Add(1) > lim.MaxIterations {
+		return n.subError(ErrMaxIterations, "maximum of %d loop iterations exceeded", lim.MaxIterations)
+	}
+	return nil
+}
+
+// write counts the output of v by n
+func (lim *limiter) write(n *internalNode, v any) error {
+	if lim.MaxOutput <= 0 {
+		return nil
+	}
+	var l int
+	switch rv := v.(type) {
+	case string:
+		l = len(rv)
+	case []byte:
+		l = len(rv)
+	case interface{ Len() int }:
+		// *bytes.Buffer
+		l = rv.Len()
+	default:
+		if s, ok := safeString(v); ok {
+			l = len(s)
+		}
+	}
+	if lim.output.Add(int64(l)) > lim.MaxOutput {
+		return n.subError(ErrMaxOutput, "maximum output of %d bytes exceeded", lim.MaxOutput)
+	}
+	return nil
+}
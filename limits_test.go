@@ -0,0 +1,137 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   tpl.Limits
+		template string
+		err      error // nil if the template must succeed
+	}{
+		{"self_link", tpl.Limits{}, `a{{main}}`, tpl.ErrMaxDepth},
+		{"self_include", tpl.Limits{}, `a{{include main}}`, tpl.ErrMaxDepth},
+		{"depth", tpl.Limits{MaxDepth: 2}, `{{level1}}`, tpl.ErrMaxDepth},
+		{"depth_ok", tpl.Limits{MaxDepth: 3}, `{{level1}}`, nil},
+		{"iterations", tpl.Limits{MaxIterations: 50}, `{{foreach _list as _a}}{{foreach _list as _b}}x{{/foreach}}{{/foreach}}`, tpl.ErrMaxIterations},
+		{"iterations_ok", tpl.Limits{MaxIterations: 110}, `{{foreach _list as _a}}{{foreach _list as _b}}x{{/foreach}}{{/foreach}}`, nil},
+		{"output_text", tpl.Limits{MaxOutput: 25}, `{{foreach _list as _a}}abc{{/foreach}}`, tpl.ErrMaxOutput},
+		{"output_value", tpl.Limits{MaxOutput: 25}, `{{foreach _list as _a}}{{_long}}{{/foreach}}`, tpl.ErrMaxOutput},
+		{"output_ok", tpl.Limits{MaxOutput: 30}, `{{foreach _list as _a}}abc{{/foreach}}`, nil},
+		{"steps", tpl.Limits{MaxSteps: 20}, `{{foreach _list as _a}}{{(_a + 1)}}{{/foreach}}`, tpl.ErrMaxSteps},
+		{"try", tpl.Limits{MaxIterations: 5}, `{{try}}{{foreach _list as _a}}x{{/foreach}}{{catch}}caught{{/try}}`, tpl.ErrMaxIterations},
+		{"try_link", tpl.Limits{MaxDepth: 5}, `{{try}}{{sub}}{{catch _e}}caught{{/try}}`, tpl.ErrMaxDepth},
+		{"try_link_break", tpl.Limits{MaxDepth: 5}, `{{foreach _list as _a}}{{try}}{{sub}}{{break}}{{catch _e}}caught{{/try}}{{/foreach}}`, tpl.ErrMaxDepth},
+	}
+
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_list": []any{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		"_long": "abcdef",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Limits = tt.limits
+			engine.Raw.TemplateData["main"] = tt.template
+			engine.Raw.TemplateData["level1"] = "1{{level2}}"
+			engine.Raw.TemplateData["level2"] = "2{{level3}}"
+			engine.Raw.TemplateData["level3"] = "3{{_long}}"
+			engine.Raw.TemplateData["sub"] = "{{sub}}"
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			_, err := engine.ParseAndReturn(ctx, "main")
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("ParseAndReturn failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) || e.Template == "" {
+				t.Errorf("expected *tpl.Error with position, got %v", err)
+			}
+
+			// limits apply to each run
+			if _, err2 := engine.ParseAndReturn(ctx, "main"); !errors.Is(err2, tt.err) {
+				t.Errorf("second run: got %v, want %v", err2, tt.err)
+			}
+		})
+	}
+}
+
+func TestLimitsContext(t *testing.T) {
+	ctx := context.Background()
+	engine := tpl.New()
+	engine.Limits = tpl.Limits{MaxOutput: 100}
+	engine.Raw.TemplateData["main"] = strings.Repeat("x", 50)
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if _, err := engine.ParseAndReturn(ctx, "main"); err != nil {
+		t.Errorf("ParseAndReturn failed: %v", err)
+	}
+	limited := tpl.WithLimits(ctx, tpl.Limits{MaxOutput: 10})
+	if _, err := engine.ParseAndReturn(limited, "main"); !errors.Is(err, tpl.ErrMaxOutput) {
+		t.Errorf("got %v, want ErrMaxOutput", err)
+	}
+}
+
+func TestLimitsSeq(t *testing.T) {
+	engine := tpl.New()
+	engine.Limits = tpl.Limits{MaxIterations: 1000}
+	engine.Raw.TemplateData["main"] = `{{foreach {{@seq(1, 100000000)}} as _i}}x{{/foreach}}`
+	if err := engine.Compile(context.Background()); !errors.Is(err, tpl.ErrMaxIterations) {
+		t.Errorf("got %v, want ErrMaxIterations", err)
+	}
+
+	// computed when running
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_n": 100000000})
+	engine.Raw.TemplateData["main"] = `{{foreach {{@seq(1, _n)}} as _i}}x{{/foreach}}`
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, err := engine.ParseAndReturn(ctx, "main"); !errors.Is(err, tpl.ErrMaxIterations) {
+		t.Errorf("got %v, want ErrMaxIterations", err)
+	}
+}
+
+func TestForeachCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	ctx = tpl.ValuesCtx(ctx, map[string]any{
+		"_list": []any{1, 2, 3, 4, 5},
+		"@stop": tpl.TplFuncCallback(func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+			count++
+			cancel()
+			return nil
+		}),
+	})
+
+	engine := tpl.New()
+	engine.MaxProcess = 1
+	engine.Raw.TemplateData["main"] = `{{foreach _list as _i}}{{@stop()}}{{/foreach}}`
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, err := engine.ParseAndReturn(ctx, "main"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if count != 1 {
+		t.Errorf("function called %d times, want 1", count)
+	}
+}
@@ -0,0 +1,86 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestLiterals(t *testing.T) {
+	tpl.RegisterFilter("literalparam", func(ctx context.Context, params tpl.Values, in tpl.Value, out tpl.WritableValue) error {
+		return out.WriteValue(ctx, params[0])
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"array", `{{[1, 2, [3]]|json()}}`, nil, `[1,2,[3]]`},
+		{"array_empty", `{{[]|json()}}`, nil, `[]`},
+		{"array_strings", `{{["a", "b"]|json()}}`, nil, `["a","b"]`},
+		{"array_float", `{{[1.5]|json()}}`, nil, `[1.5]`},
+		{"map", `{{{"key": "a", "n": 2}|json()}}`, nil, `{"key":"a","n":2}`},
+		{"map_empty", `{{{}|json()}}`, nil, `{}`},
+		{"map_nested", `{{{"a": {"b": [1, {"c": 2}]}}|json()}}`, nil, `{"a":{"b":[1,{"c":2}]}}`},
+		{"map_variable", `{{{"key": _x, "n": 1 + 1}|json()}}`, map[string]any{"_x": "v"}, `{"key":"v","n":2}`},
+		{"map_variable_path", `{{{"city": _user/city}|json()}}`, map[string]any{"_user": map[string]any{"city": "Paris"}}, `{"city":"Paris"}`},
+		{"array_variable", `{{[_x, 3]|json()}}`, map[string]any{"_x": []any{1, 2}}, `[[1,2],3]`},
+		{"variable_division", `{{(_a/2)}}`, map[string]any{"_a": 6}, `3`},
+		{"index", `{{([10, 20, 30][1])}}`, nil, `20`},
+		{"lookup", `{{({"a": "Alpha", "b": "Beta"}[_k])}}`, map[string]any{"_k": "b"}, `Beta`},
+		{"length", `{{[1, 2, 3]|length()}}{{{"a": 1}|length()}}`, nil, `31`},
+		{"in", `{{if 2 in [1, 2]}}yes{{/if}}`, nil, `yes`},
+		{"ternary_in_map", `{{{"a": 1 ? "x" : "y"}|json()}}`, nil, `{"a":"x"}`},
+		{"set", `{{set _u={"name": "John", "address": {"city": "Paris"}}}}{{_u/name}}, {{_u/address/city}}{{/set}}`, nil, `John, Paris`},
+		{"foreach_array", `{{foreach [1, 2, 3] as _v}}{{_v}};{{/foreach}}`, nil, `1;2;3;`},
+		{"foreach_map", `{{foreach {"b": 1, "a": 2} as _k => _v}}{{_k}}={{_v}};{{/foreach}}`, nil, `a=2;b=1;`},
+		{"foreach_filter", `{{foreach ["a", "b"]|reverse() as _v}}{{_v}}{{/foreach}}`, nil, `ba`},
+		{"filter_param", `{{_x|literalparam({"a": [1]})|json()}}`, nil, `{"a":[1]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), tt.vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLiteralErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"map_missing_colon", `{{{"a" 1}|json()}}`},
+		{"map_missing_value", `{{{"a":}|json()}}`},
+		{"map_not_closed", `{{{"a": 1}}`},
+		{"array_not_closed", `{{[1, 2}}`},
+		{"colon_outside_map", `{{(1 : 2)}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestBreakContinue(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"break", `{{foreach {{_l}} as _v}}{{_v}}{{if {{_v}} == 3}}{{break}}{{/if}},{{/foreach}}`, "1,2,3"},
+		{"break_if", `{{foreach {{_l}} as _v}}{{break if {{_v}} > 2}}{{_v}},{{/foreach}}`, "1,2,"},
+		{"continue", `{{foreach {{_l}} as _v}}{{if {{_v}} % 2 == 0}}{{continue}}{{/if}}{{_v}},{{/foreach}}`, "1,3,5,"},
+		{"continue_if", `{{foreach {{_l}} as _v}}{{continue if {{_v}} < 4}}{{_v}},{{/foreach}}`, "4,5,"},
+		{"break_first", `[{{foreach {{_l}} as _v}}{{break}}{{_v}}{{/foreach}}]`, "[]"},
+		{"break_no_else", `{{foreach {{_l}} as _v}}{{break}}{{else}}empty{{/foreach}}`, ""},
+		{"in_set", `{{foreach {{_l}} as _v}}{{set _x="a"}}{{_x}}{{break if {{_v}} == 2}}{{/set}}{{_v}}{{/foreach}}`, "a1a"},
+		{"in_try", `{{foreach {{_l}} as _v}}{{try}}<{{_v}}{{break if {{_v}} == 2}}>{{catch}}caught{{/try}}{{/foreach}}`, "<1><2"},
+		{"in_switch", `{{foreach {{_l}} as _v}}{{switch {{_v}}}}{{case 2}}{{continue}}{{case 4}}{{break}}{{/switch}}{{_v}}{{/foreach}}`, "13"},
+		{"nested_inner", `{{foreach {{_l}} as _a}}{{break if {{_a}} > 2}}{{foreach {{_l}} as _b}}{{break if {{_b}} > {{_a}}}}{{_b}}{{/foreach}};{{/foreach}}`, "1;12;"},
+		{"error_after_break", `{{foreach {{_l}} as _v}}{{_v}}{{break}}{{@error("boom")}}{{/foreach}}`, "1"},
+		{"error_after_continue", `{{foreach {{_l}} as _v}}{{_v}}{{continue}}{{@error("boom")}}{{/foreach}}`, "12345"},
+		{"trim_blocks", "{{foreach {{_l}} as _v}}\n{{break if {{_v}} > 1}}\n{{_v}}\n{{/foreach}}\n", "1\n"},
+	}
+
+	for _, tt := range tests {
+		for _, maxProcess := range []int{0, 1} {
+			t.Run(tt.name, func(t *testing.T) {
+				engine := tpl.New()
+				engine.MaxProcess = maxProcess
+				engine.TrimBlocks = tt.name == "trim_blocks"
+				engine.Raw.TemplateData["main"] = tt.template
+
+				ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_l": []any{1, 2, 3, 4, 5}})
+				if err := engine.Compile(ctx); err != nil {
+					t.Fatalf("Compile failed: %v", err)
+				}
+
+				result, err := engine.ParseAndReturn(ctx, "main")
+				if err != nil {
+					t.Fatalf("ParseAndReturn failed: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("MaxProcess=%d: got %q, want %q", maxProcess, result, tt.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestBreakContinueErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"break_outside", `{{break}}`},
+		{"continue_outside", `{{if 1}}{{continue}}{{/if}}`},
+		{"break_in_else", `{{foreach {{_l}} as _v}}x{{else}}{{break}}{{/foreach}}`},
+		{"break_invalid", `{{foreach {{_l}} as _v}}{{break now}}{{/foreach}}`},
+		{"break_if_empty", `{{foreach {{_l}} as _v}}{{break if}}{{/foreach}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package tpl
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
 )
 
 var math_operators = map[string]int{
@@ -16,6 +21,7 @@ var math_operators = map[string]int{
 	"-":  4,
 	"<<": 5,
 	">>": 5,
+	"..": 5,
 	"<":  6,
 	"<=": 6,
 	">":  6,
@@ -27,7 +33,87 @@ var math_operators = map[string]int{
 	"|":  10,
 	"&&": 11,
 	"||": 12,
+	"??": 13,
+	"?":  14,
+	":":  14,
 	",":  900,
+
+	// keyword operators
+	"in":     6,
+	"not in": 6,
+}
+
+// mathKeywordOperator returns the keyword operator found at the start of txt
+// (such as "in" or "not in") and its length in txt, if any
+func mathKeywordOperator(txt string) (string, int) {
+	word := func(s, w string) bool {
+		if !strings.HasPrefix(s, w) {
+			return false
+		}
+		if len(s) > len(w) {
+			c := s[len(w)]
+			return !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
+		}
+		return true
+	}
+
+	switch {
+	case word(txt, "in"):
+		return "in", 2
+	case word(txt, "not"):
+		rest := strings.TrimLeft(txt[3:], " \t\r\n")
+		if len(rest) < len(txt)-3 && word(rest, "in") {
+			return "not in", len(txt) - len(rest) + 2
+		}
+	}
+	return "", 0
+}
+
+// mathInOperator returns true if needle can be found in haystack. Strings are
+// searched for a substring, anything else for a value equal to needle.
+func mathInOperator(ctx context.Context, needle, haystack Value) (bool, error) {
+	o1, err := needle.WithCtx(ctx).Raw()
+	if err != nil {
+		return false, err
+	}
+	o2, err := haystack.WithCtx(ctx).Raw()
+	if err != nil {
+		return false, err
+	}
+	if s, ok := safeString(o2); ok {
+		o2 = s
+	}
+	switch h := o2.(type) {
+	case nil:
+		return false, nil
+	case string:
+		return strings.Contains(h, NewValue(o1).WithCtx(ctx).String()), nil
+	case []byte:
+		return bytes.Contains(h, NewValue(o1).WithCtx(ctx).Bytes()), nil
+	case *bytes.Buffer:
+		return bytes.Contains(h.Bytes(), NewValue(o1).WithCtx(ctx).Bytes()), nil
+	}
+
+	found := false
+	_, err = foreachAny(ctx, o2, func(k, v any, idx, max int64) error {
+		if vv, ok := v.(Value); ok {
+			var err error
+			if v, err = vv.WithCtx(ctx).Raw(); err != nil {
+				return err
+			}
+		}
+		// same comparison as ==, values that cannot be compared such as
+		// "x" and 1 do not match
+		if r, err := CompareValues(ctx, o1, v); err == nil && r {
+			found = true
+			return errLoopBreak
+		}
+		return nil
+	})
+	if err == errLoopBreak {
+		err = nil
+	}
+	return found, err
 }
 
 func mathSingleValueOperator(ctx context.Context, op string, val1 Value) (*interfaceValue, error) {
@@ -53,7 +139,75 @@ func mathSingleValueOperator(ctx context.Context, op string, val1 Value) (*inter
 	return &interfaceValue{}, fmt.Errorf("unrecognized operator %s", op)
 }
 
+// mathStrings returns the values as strings if both are strings and at
+// least one of them isn't a number, meaning they should be compared as
+// strings
+func mathStrings(o1, o2 any) (string, string, bool) {
+	str := func(o any) (string, bool) {
+		switch v := o.(type) {
+		case string:
+			return v, true
+		case []byte:
+			return string(v), true
+		case *bytes.Buffer:
+			return v.String(), true
+		}
+		return safeString(o)
+	}
+	isNum := func(s string) bool {
+		_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return err == nil
+	}
+
+	s1, ok1 := str(o1)
+	s2, ok2 := str(o2)
+	if !ok1 || !ok2 || (isNum(s1) && isNum(s2)) {
+		return "", "", false
+	}
+	return s1, s2, true
+}
+
+// mathStringCompare compares strings, using the collation of the current
+// language if the context has one
+func mathStringCompare(ctx context.Context, s1, s2 string) int {
+	if _, ok := ctx.Value("_language").(language.Tag); ok {
+		return compareCollated(ctx, s1, s2)
+	}
+	return strings.Compare(s1, s2)
+}
+
 func mathValueOperator(ctx context.Context, op string, val1, val2 Value) (Value, error) {
+	if op == ".." {
+		// string concatenation
+		s1 := AsOutValue(ctx, val1).WithCtx(ctx).String()
+		s2 := AsOutValue(ctx, val2).WithCtx(ctx).String()
+		return NewValue(s1 + s2), nil
+	}
+
+	if op == "<" || op == "<=" || op == ">" || op == ">=" {
+		o1, err := val1.WithCtx(ctx).Raw()
+		if err != nil {
+			return nil, err
+		}
+		o2, err := val2.WithCtx(ctx).Raw()
+		if err != nil {
+			return nil, err
+		}
+		if s1, s2, ok := mathStrings(o1, o2); ok {
+			c := mathStringCompare(ctx, s1, s2)
+			switch op {
+			case "<":
+				return NewValue(c < 0), nil
+			case "<=":
+				return NewValue(c <= 0), nil
+			case ">":
+				return NewValue(c > 0), nil
+			default:
+				return NewValue(c >= 0), nil
+			}
+		}
+	}
+
 	if op == "==" || op == "!=" {
 		// handle comparisons with go reflection
 		o1, err := val1.WithCtx(ctx).Raw()
@@ -86,11 +240,21 @@ func mathValueOperator(ctx context.Context, op string, val1, val2 Value) (Value,
 		return &interfaceValue{mathValueOperatorBool(op, asBoolIntf(b1), asBoolIntf(b2))}, nil
 	}
 
-	o1, err := AsOutValue(ctx, val1).AsNumeric(ctx).ReadValue(ctx)
+	// read values first so errors (such as from a function) are returned
+	r1, err := val1.WithCtx(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	r2, err := val2.WithCtx(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	o1, err := AsOutValue(ctx, r1).AsNumeric(ctx).ReadValue(ctx)
 	if err != nil {
 		return nil, err
 	}
-	o2 := AsOutValue(ctx, val2).AsNumeric(ctx)
+	o2 := AsOutValue(ctx, r2).AsNumeric(ctx)
 
 	switch v1 := o1.(type) {
 	case int64:
@@ -3,9 +3,11 @@ package tpl_test
 import (
 	"context"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/KarpelesLab/tpl"
+	"golang.org/x/text/language"
 )
 
 func TestIfCondition(t *testing.T) {
@@ -796,3 +798,162 @@ func TestNestedMathExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestConditionalOperators(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"ternary_true", `{{(1 < 2) ? "yes" : "no"}}`, nil, "yes"},
+		{"ternary_false", `{{(1 > 2) ? "yes" : "no"}}`, nil, "no"},
+		{"ternary_var", `{{({{_a}} ? "on" : "off")}}`, map[string]any{"_a": false}, "off"},
+		{"ternary_precedence", `{{(1 + 1 == 2 ? 3 * 2 : 0)}}`, nil, "6"},
+		{"ternary_chained", `{{(0 ? "a" : 1 ? "b" : "c")}}`, nil, "b"},
+		{"ternary_nested", `{{(1 ? 0 ? "a" : "b" : "c")}}`, nil, "b"},
+		{"ternary_in_set", `{{set _x=({{_a}} > 10 ? "big" : "small")}}{{_x}}{{/set}}`, map[string]any{"_a": 42}, "big"},
+		{"ternary_in_list", `{{@printf("%s-%s", (1 ? "a" : "b"), "c")}}`, nil, "a-c"},
+		{"ternary_short_circuit", `{{(1 ? 2 : {{@error("not evaluated")}})}}`, nil, "2"},
+		{"coalesce_null", `{{({{_none}} ?? "default")}}`, nil, "default"},
+		{"coalesce_value", `{{({{_a}} ?? "default")}}`, map[string]any{"_a": "set"}, "set"},
+		{"coalesce_false", `{{({{_a}} ?? "default")}}`, map[string]any{"_a": 0}, "0"},
+		{"coalesce_chain", `{{({{_x}} ?? {{_y}} ?? "last")}}`, nil, "last"},
+		{"coalesce_short_circuit", `{{({{_a}} ?? {{@error("not evaluated")}})}}`, map[string]any{"_a": "x"}, "x"},
+		{"coalesce_ternary", `{{({{_a}} ?? 0 ? "yes" : "no")}}`, nil, "no"},
+		{"in_list", `{{if {{_a}} in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "b", "_l": []string{"a", "b"}}, "yes"},
+		{"in_list_missing", `{{if {{_a}} in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "z", "_l": []string{"a", "b"}}, "no"},
+		{"in_literal_list", `{{if 2 in (1, 2, 3)}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"in_loose", `{{if "2" in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_l": []any{1, 2}}, "yes"},
+		{"in_string", `{{if "ell" in "hello"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"in_map_values", `{{if "x" in {{_m}}}}yes{{else}}no{{/if}}`, map[string]any{"_m": map[string]any{"a": "x"}}, "yes"},
+		{"in_mixed_list", `{{("x" in [1, 2])}}`, nil, ""},
+		{"in_mixed_list_found", `{{if "x" in [1, "x"]}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"in_mixed_map", `{{if "x" in {"a": 1}}}yes{{else}}no{{/if}}`, nil, "no"},
+		{"in_mixed_vars", `{{if {{_a}} in {{_m}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "x", "_m": map[string]any{"a": 1, "b": "x"}}, "yes"},
+		{"not_in_mixed", `{{if {{_a}} not in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "x", "_l": []any{1, 2}}, "yes"},
+		{"in_null", `{{if 1 in {{_none}}}}yes{{else}}no{{/if}}`, nil, "no"},
+		{"not_in", `{{if {{_a}} not in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "z", "_l": []string{"a", "b"}}, "yes"},
+		{"not_in_found", `{{if {{_a}} not  in {{_l}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "a", "_l": []string{"a", "b"}}, "no"},
+		{"in_and", `{{if 1 in (1, 2) && 3 in (3)}}yes{{else}}no{{/if}}`, nil, "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), tt.vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConditionalOperatorErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"colon_alone", `{{(1 : 2)}}`},
+		{"missing_colon", `{{(1 ? 2)}}`},
+		{"missing_else", `{{(1 ? 2 :)}}`},
+		{"missing_cond", `{{(? 1 : 2)}}`},
+		{"in_without_space", `{{(1 inx)}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
+
+func TestStringOperators(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"concat", `{{("a" .. "b")}}`, nil, "ab"},
+		{"concat_vars", `{{set _x=({{_a}} .. "-" .. {{_b}})}}{{_x}}{{/set}}`, map[string]any{"_a": "foo", "_b": 42}, "foo-42"},
+		{"concat_numbers", `{{(1 .. 2)}}`, nil, "12"},
+		{"concat_precedence", `{{("total: " .. 1 + 2)}}`, nil, "total: 3"},
+		{"concat_null", `{{("a" .. {{_none}} .. "b")}}`, nil, "ab"},
+		{"concat_compare", `{{if "a" .. "b" == "ab"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"decimal_still_works", `{{(1.5 + 1.5)}}`, nil, "3"},
+		{"string_gt", `{{if "b" > "a"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"string_lt", `{{if {{_a}} < {{_b}}}}yes{{else}}no{{/if}}`, map[string]any{"_a": "SKU-100", "_b": "SKU-200"}, "yes"},
+		{"string_le_equal", `{{if "abc" <= "abc"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"string_ge", `{{if "abc" >= "abd"}}yes{{else}}no{{/if}}`, nil, "no"},
+		{"numeric_strings", `{{if "10" > "9"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"number_vs_number", `{{if {{_a}} > 9}}yes{{else}}no{{/if}}`, map[string]any{"_a": 10}, "yes"},
+		{"no_language_bytes", `{{if "öl" > "zebra"}}yes{{else}}no{{/if}}`, nil, "yes"},
+		{"language_en", `{{if "öl" > "zebra"}}yes{{else}}no{{/if}}`, map[string]any{"_language": language.English}, "no"},
+		{"language_sv", `{{if "öl" > "zebra"}}yes{{else}}no{{/if}}`, map[string]any{"_language": language.Swedish}, "yes"},
+		{"language_case", `{{if "apple" < "Banana"}}yes{{else}}no{{/if}}`, map[string]any{"_language": language.English}, "yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), tt.vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStringCompareConcurrent(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{if "öl" > "zebra"}}yes{{else}}no{{/if}}`
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	// collators are shared between runs of the same language
+	var wg sync.WaitGroup
+	for _, lng := range []language.Tag{language.English, language.Swedish, language.English, language.Swedish} {
+		expected := "no"
+		if lng == language.Swedish {
+			expected = "yes"
+		}
+		ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_language": lng})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if res, err := engine.ParseAndReturn(ctx, "main"); err != nil || res != expected {
+					t.Errorf("%s: got %q, %v, want %q", lng, res, err, expected)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -51,6 +51,9 @@ func asBoolIntf(v interface{}) bool {
 			return false
 		}
 		return true
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		str, _ := safeString(r)
+		return asBoolIntf(str)
 	case map[string]interface{}:
 		if len(r) > 0 {
 			return true
@@ -315,6 +318,9 @@ func fetchNumberAny(ctx context.Context, v interface{}) (interface{}, bool) {
 			return fetchNumberAny(ctx, nVal)
 		}
 	}
+	if str, ok := safeString(v); ok {
+		return fetchNumberAny(ctx, str)
+	}
 
 	return nil, false
 }
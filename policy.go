@@ -0,0 +1,93 @@
+package tpl
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Policy restricts the functions and filters the templates of a page can
+// call, such as templates written by users. Calls are checked when
+// compiling and again when running. Functions and filters passed through
+// the context are checked like the others.
+type Policy struct {
+	// AllowFunctions lists the only functions that can be called, without
+	// @. If nil, all functions can be called.
+	AllowFunctions []string
+	// DenyFunctions lists functions that cannot be called, without @.
+	DenyFunctions []string
+	// AllowFilters lists the only filters that can be called. If nil, all
+	// filters can be called.
+	AllowFilters []string
+	// DenyFilters lists filters that cannot be called.
+	DenyFilters []string
+}
+
+// SandboxPolicy returns a policy for templates that are not trusted. It
+// denies functions that stop the page or reveal information about the
+// server, @printf, and filters that write values as is or dump them.
+func SandboxPolicy() *Policy {
+	return &Policy{
+		DenyFunctions: []string{"error", "redirect", "printf", "uname", "phpversion"},
+		DenyFilters:   []string{"raw", "dump", "export"},
+	}
+}
+
+// PolicyError is the error, wrapped in an *Error, returned when a template
+// calls a function or filter denied by the Policy of its page
+type PolicyError struct {
+	Kind string // "function" or "filter"
+	Name string // name of the function or filter, without @
+}
+
+func (e *PolicyError) Error() string {
+	if e.Kind == "function" {
+		return fmt.Sprintf("tpl: function @%s is not allowed", e.Name)
+	}
+	return fmt.Sprintf("tpl: %s %s is not allowed", e.Kind, e.Name)
+}
+
+func allowed(allow, deny []string, name string) bool {
+	if allow != nil && !slices.Contains(allow, name) {
+		return false
+	}
+	return !slices.Contains(deny, name)
+}
+
+// checkFunction returns a *PolicyError if p does not allow function name.
+// A nil policy allows everything.
+func (p *Policy) checkFunction(name string) error {
+	if p == nil || allowed(p.AllowFunctions, p.DenyFunctions, name) {
+		return nil
+	}
+	return &PolicyError{Kind: "function", Name: name}
+}
+
+// checkFilter returns a *PolicyError if p does not allow filter name
+func (p *Policy) checkFilter(name string) error {
+	if p == nil || allowed(p.AllowFilters, p.DenyFilters, name) {
+		return nil
+	}
+	return &PolicyError{Kind: "filter", Name: name}
+}
+
+// checkPolicy makes sure the compiled templates only call functions and
+// filters allowed by the policy of the page
+func (e *Page) checkPolicy(compiled map[string]internalArray) error {
+	var errs ErrorList
+	for _, a := range compiled {
+		a.walk(func(n *internalNode) error {
+			var err error
+			switch n.typ {
+			case internalFunc:
+				err = e.Policy.checkFunction(n.str)
+			case internalFilter:
+				err = e.Policy.checkFilter(n.str)
+			}
+			if err != nil {
+				errs.add("", n.subError(err, "%s", err))
+			}
+			return nil
+		})
+	}
+	return errs.err()
+}
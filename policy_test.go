@@ -0,0 +1,103 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *tpl.Policy
+		template string
+		denied   string // name of the denied function or filter, empty if allowed
+		expected string
+	}{
+		{"nil", nil, `{{@string("a")}}{{"a"|uppercase()}}`, "", "aA"},
+		{"deny_function", &tpl.Policy{DenyFunctions: []string{"printf"}}, `{{@string("a")}}{{@printf("%s", _x)}}`, "printf", ""},
+		{"deny_static_function", &tpl.Policy{DenyFunctions: []string{"seq"}}, `{{foreach {{@seq(1, 3)}} as _i}}{{_i}}{{/foreach}}`, "seq", ""},
+		{"allow_function", &tpl.Policy{AllowFunctions: []string{"string"}}, `{{@string("a")}}`, "", "a"},
+		{"not_allowed_function", &tpl.Policy{AllowFunctions: []string{"string"}}, `{{if _x}}{{@rand(1, 2)}}{{/if}}`, "rand", ""},
+		{"deny_filter", &tpl.Policy{DenyFilters: []string{"dump"}}, `{{_x|uppercase()|dump()}}`, "dump", ""},
+		{"allow_filter", &tpl.Policy{AllowFilters: []string{"uppercase"}}, `{{_x|uppercase()}}`, "", "B"},
+		{"not_allowed_filter", &tpl.Policy{AllowFilters: []string{}}, `{{if _x}}{{_x|uppercase()}}{{/if}}`, "uppercase", ""},
+		{"context_function", &tpl.Policy{DenyFunctions: []string{"hello"}}, `{{@hello()}}`, "hello", ""},
+		{"sandbox", tpl.SandboxPolicy(), `{{@redirect("/")}}`, "redirect", ""},
+		{"sandbox_raw", tpl.SandboxPolicy(), `{{_x|raw()}}`, "raw", ""},
+		{"sandbox_allowed", tpl.SandboxPolicy(), `{{_x|lowercase()}}{{@string("c")}}`, "", "bc"},
+	}
+
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_x": "b",
+		"@hello": tpl.TplFuncCallback(func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+			return out.WriteValue(ctx, "hello")
+		}),
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Policy = tt.policy
+			engine.Raw.TemplateData["main"] = tt.template
+			err := engine.Compile(ctx)
+			if tt.denied == "" {
+				if err != nil {
+					t.Fatalf("Compile failed: %v", err)
+				}
+				result, err := engine.ParseAndReturn(ctx, "main")
+				if err != nil {
+					t.Fatalf("ParseAndReturn failed: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("got %q, want %q", result, tt.expected)
+				}
+				return
+			}
+			var pe *tpl.PolicyError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected *tpl.PolicyError, got %v", err)
+			}
+			if pe.Name != tt.denied {
+				t.Errorf("got denied %s %s, want %s", pe.Kind, pe.Name, tt.denied)
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) || e.Line != 1 {
+				t.Errorf("expected *tpl.Error with position, got %v", err)
+			}
+
+			// compiled without the policy, it is enforced when running
+			engine.Policy = nil
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile without policy failed: %v", err)
+			}
+			engine.Policy = tt.policy
+			if tt.name == "deny_static_function" {
+				// the call ran when compiling
+				return
+			}
+			if _, err := engine.ParseAndReturn(ctx, "main"); !errors.As(err, &pe) {
+				t.Errorf("expected *tpl.PolicyError when running, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPolicyCompileTemplate(t *testing.T) {
+	engine := tpl.New()
+	engine.Policy = tpl.SandboxPolicy()
+	engine.Raw.TemplateData["main"] = "{{body}}"
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	err := engine.CompileTemplate(context.Background(), "body", `{{@error("x")}}`)
+	var pe *tpl.PolicyError
+	if !errors.As(err, &pe) || pe.Kind != "function" || pe.Name != "error" {
+		t.Errorf("expected *tpl.PolicyError for @error, got %v", err)
+	}
+	if pe != nil && pe.Error() != "tpl: function @error is not allowed" {
+		t.Errorf("got message %q", pe.Error())
+	}
+}
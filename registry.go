@@ -0,0 +1,115 @@
+package tpl
+
+import (
+	"context"
+	"sync"
+)
+
+// Registry holds functions and filters that templates can call. A page
+// uses the registry set in its Registry field, which falls back to the
+// functions and filters registered with RegisterFunction and
+// RegisterFilter. A Registry is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	functions map[string]*TplFunction
+	filters   map[string]TplFiltCallback
+	parent    *Registry
+}
+
+// defaultRegistry holds the functions and filters available to all pages
+var defaultRegistry = &Registry{}
+
+// NewRegistry returns an empty registry falling back to the default
+// functions and filters.
+func NewRegistry() *Registry {
+	return &Registry{parent: defaultRegistry}
+}
+
+// RegisterFunction makes function name available to the templates of every
+// page. It can be called while templates are running.
+func RegisterFunction(name string, f *TplFunction) {
+	defaultRegistry.RegisterFunction(name, f)
+}
+
+// RegisterFilter makes filter name available to the templates of every
+// page. It can be called while templates are running.
+func RegisterFilter(name string, f TplFiltCallback) {
+	defaultRegistry.RegisterFilter(name, f)
+}
+
+// RegisterFunction adds function name to r, replacing any function of the
+// same name in r or its defaults
+func (r *Registry) RegisterFunction(name string, f *TplFunction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.functions == nil {
+		r.functions = make(map[string]*TplFunction)
+	}
+	r.functions[name] = f
+}
+
+// RegisterFilter adds filter name to r, replacing any filter of the same
+// name in r or its defaults
+func (r *Registry) RegisterFilter(name string, f TplFiltCallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.filters == nil {
+		r.filters = make(map[string]TplFiltCallback)
+	}
+	r.filters[name] = f
+}
+
+// Function returns function name from r or its defaults
+func (r *Registry) Function(name string) (*TplFunction, bool) {
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		f, ok := r.functions[name]
+		r.mu.RUnlock()
+		if ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Filter returns filter name from r or its defaults
+func (r *Registry) Filter(name string) (TplFiltCallback, bool) {
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		f, ok := r.filters[name]
+		r.mu.RUnlock()
+		if ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// registry returns the registry used by the templates of e
+func (e *Page) registry() *Registry {
+	if e == nil || e.Registry == nil {
+		return defaultRegistry
+	}
+	return e.Registry
+}
+
+// function returns the callback of function name, which can be set in ctx
+// as "@name" for a single run
+func (e *Page) function(ctx context.Context, name string) (TplFuncCallback, bool) {
+	if f, ok := ctx.Value("@" + name).(TplFuncCallback); ok {
+		return f, true
+	}
+	if f, ok := e.registry().Function(name); ok {
+		return f.Method, true
+	}
+	return nil, false
+}
+
+// filter returns the callback of filter name, which can be set in ctx as
+// "|name" for a single run
+func (e *Page) filter(ctx context.Context, name string) (TplFiltCallback, bool) {
+	if f, ok := ctx.Value("|" + name).(TplFiltCallback); ok {
+		return f, true
+	}
+	return e.registry().Filter(name)
+}
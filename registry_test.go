@@ -0,0 +1,124 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func suffixFilter(suffix string) tpl.TplFiltCallback {
+	return func(ctx context.Context, params tpl.Values, in tpl.Value, out tpl.WritableValue) error {
+		return out.WriteValue(ctx, in.WithCtx(ctx).String()+suffix)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := tpl.NewRegistry()
+	reg.RegisterFilter("price", suffixFilter(" EUR"))
+	reg.RegisterFilter("uppercase", suffixFilter("!"))
+	reg.RegisterFunction("answer", &tpl.TplFunction{Method: func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+		return out.WriteValue(ctx, 42)
+	}, CanCompile: true})
+
+	tenantCtx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"|price": suffixFilter(" USD"),
+		"@answer": tpl.TplFuncCallback(func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+			return out.WriteValue(ctx, 43)
+		}),
+	})
+
+	tests := []struct {
+		name     string
+		registry *tpl.Registry
+		ctx      context.Context
+		template string
+		expected string
+	}{
+		{"registry_filter", reg, context.Background(), `{{"5"|price()}}`, "5 EUR"},
+		{"registry_override", reg, context.Background(), `{{"a"|uppercase()}}`, "a!"},
+		{"registry_fallback", reg, context.Background(), `{{"a"|lowercase()}}{{@string("b")}}`, "ab"},
+		{"registry_function", reg, context.Background(), `{{@answer()}}`, "42"},
+		{"default", nil, context.Background(), `{{"a"|uppercase()}}`, "A"},
+		{"default_price", nil, context.Background(), `{{"5"|price()}}`, "N/A"},
+		{"context_filter", reg, tenantCtx, `{{"5"|price()}}`, "5 USD"},
+		{"context_filter_default", nil, tenantCtx, `{{"5"|price()}}`, "5 USD"},
+		{"context_function", nil, tenantCtx, `{{@answer()}}`, "43"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Registry = tt.registry
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(tt.ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegistryStrict(t *testing.T) {
+	engine := tpl.New()
+	engine.Strict = true
+	engine.Raw.TemplateData["main"] = `{{"5"|vat()}}`
+	err := engine.Compile(context.Background())
+	var e *tpl.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *tpl.Error, got %v", err)
+	}
+
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"|vat": suffixFilter(" USD")})
+	if err := engine.Compile(ctx); err != nil {
+		t.Errorf("Compile with context filter failed: %v", err)
+	}
+
+	engine.Registry = tpl.NewRegistry()
+	engine.Registry.RegisterFilter("vat", suffixFilter(" EUR"))
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Errorf("Compile with registry failed: %v", err)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	engine := tpl.New()
+	engine.Registry = tpl.NewRegistry()
+	engine.Raw.TemplateData["main"] = `{{"a"|lowercase()|f0()}}`
+	engine.Registry.RegisterFilter("f0", suffixFilter(""))
+	if err := engine.Compile(context.Background()); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				name := fmt.Sprintf("registry_test_%d_%d", i, j)
+				engine.Registry.RegisterFilter(name, suffixFilter(""))
+				tpl.RegisterFilter(name, suffixFilter(""))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if res, err := engine.ParseAndReturn(context.Background(), "main"); err != nil || res != "a" {
+					t.Errorf("got %q, %v", res, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
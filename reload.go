@@ -0,0 +1,164 @@
+package tpl
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reloader polls the directories a page was loaded from and recompiles the
+// templates that changed, so templates can be edited without restarting.
+// Changes to _properties.json are not reloaded.
+type Reloader struct {
+	// Interval is the delay between checks in Run, defaults to one second
+	Interval time.Duration
+
+	page  *Page
+	src   fs.FS // nil for directories on disk
+	dirs  []string
+	state map[string]reloadFile
+	// failed is the state of files when a change failed, which is not
+	// retried until files change again
+	failed map[string]reloadFile
+}
+
+// reloadFile is the last known state of a template file
+type reloadFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader returns a Reloader for a page loaded with FromVfs(src, dirs...)
+func NewReloader(e *Page, src fs.FS, dirs ...string) *Reloader {
+	return &Reloader{page: e, src: src, dirs: dirs}
+}
+
+// NewDirReloader returns a Reloader for a page loaded with FromDir(dirs...)
+func NewDirReloader(e *Page, dirs ...string) *Reloader {
+	return &Reloader{page: e, dirs: dirs}
+}
+
+// scan returns the current state of template files. As when loading, files in
+// later directories replace files of the same name in earlier ones.
+func (r *Reloader) scan() (map[string]reloadFile, error) {
+	res := make(map[string]reloadFile)
+	for _, d := range r.dirs {
+		var entries []fs.DirEntry
+		var err error
+		if r.src == nil {
+			entries, err = os.ReadDir(d)
+		} else {
+			entries, err = fs.ReadDir(r.src, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range entries {
+			n := ent.Name()
+			if ent.IsDir() || !strings.HasSuffix(n, ".tpl") {
+				continue
+			}
+			nfo, err := ent.Info()
+			if err != nil {
+				return nil, err
+			}
+			f := reloadFile{modTime: nfo.ModTime(), size: nfo.Size()}
+			if r.src == nil {
+				f.path = filepath.Join(d, n)
+			} else {
+				f.path = path.Join(d, n)
+			}
+			res[n[:len(n)-4]] = f
+		}
+	}
+	return res, nil
+}
+
+// Check recompiles templates that were changed or added since the previous
+// call, and removes templates whose file was deleted. The first call only
+// records the state of files. Changes are applied together, so new templates
+// can include each other. If a template fails to compile, no change is
+// applied, errors are returned as an ErrorList, and changes are retried once
+// another file changes.
+func (r *Reloader) Check(ctx context.Context) error {
+	files, err := r.scan()
+	if err != nil {
+		return err
+	}
+	if r.state == nil {
+		r.state = files
+		return nil
+	}
+	if r.failed != nil && maps.Equal(files, r.failed) {
+		return nil
+	}
+
+	sources := make(map[string]string)
+	var removed []string
+	for name, f := range files {
+		if old, ok := r.state[name]; ok && old == f {
+			continue
+		}
+		var data []byte
+		if r.src == nil {
+			data, err = grabFile(f.path)
+		} else {
+			data, err = grabVfsFile(r.src, f.path)
+		}
+		if err != nil {
+			return err
+		}
+		LogDebug(ctx, "Reloading template", "template", name, "path", f.path)
+		sources[name] = string(data)
+	}
+	for name := range r.state {
+		if _, ok := files[name]; !ok {
+			LogDebug(ctx, "Removing deleted template", "template", name)
+			removed = append(removed, name)
+		}
+	}
+	if len(sources) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	r.page.compileMu.Lock()
+	err = r.page.updateTemplates(ctx, sources, removed)
+	r.page.compileMu.Unlock()
+	if err != nil {
+		r.failed = files
+		return err
+	}
+	r.state = files
+	r.failed = nil
+	return nil
+}
+
+// Run calls Check at each Interval until ctx is done, logging errors
+func (r *Reloader) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if err := r.Check(ctx); err != nil {
+		LogError(ctx, err, "failed to reload templates")
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if err := r.Check(ctx); err != nil {
+				LogError(ctx, err, "failed to reload templates")
+			}
+		}
+	}
+}
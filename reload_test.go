@@ -0,0 +1,285 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestCompileTemplate(t *testing.T) {
+	ctx := context.Background()
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = "[{{header}}]"
+	engine.Raw.TemplateData["header"] = "v1"
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	check := func(expected string) {
+		t.Helper()
+		result, err := engine.ParseAndReturn(ctx, "main")
+		if err != nil {
+			t.Fatalf("ParseAndReturn failed: %v", err)
+		}
+		if result != expected {
+			t.Errorf("got %q, want %q", result, expected)
+		}
+	}
+
+	if err := engine.CompileTemplate(ctx, "header", "v2"); err != nil {
+		t.Fatalf("CompileTemplate failed: %v", err)
+	}
+	check("[v2]")
+
+	// a broken template keeps the previous version
+	err := engine.CompileTemplate(ctx, "header", "{{/if}}")
+	var e *tpl.Error
+	if !errors.As(err, &e) || e.Template != "header" {
+		t.Fatalf("expected error in header, got %v", err)
+	}
+	check("[v2]")
+
+	// includes are checked against the other templates
+	if err := engine.CompileTemplate(ctx, "header", "{{include nosuch}}"); err == nil {
+		t.Errorf("expected error for include of missing template")
+	}
+	check("[v2]")
+
+	if err := engine.CompileTemplate(ctx, "footer", "end"); err != nil {
+		t.Fatalf("CompileTemplate failed: %v", err)
+	}
+	if !engine.HasTpl("footer") {
+		t.Errorf("footer was not added")
+	}
+
+	// included templates cannot be removed
+	if err := engine.CompileTemplate(ctx, "page", "{{include footer}}"); err != nil {
+		t.Fatalf("CompileTemplate failed: %v", err)
+	}
+	if err := engine.RemoveTemplate(ctx, "footer"); err == nil {
+		t.Errorf("expected error when removing an included template")
+	}
+	if !engine.HasTpl("footer") {
+		t.Errorf("footer was removed")
+	}
+
+	if err := engine.RemoveTemplate(ctx, "header"); err != nil {
+		t.Fatalf("RemoveTemplate failed: %v", err)
+	}
+	if engine.HasTpl("header") {
+		t.Errorf("header was not removed")
+	}
+	check("[]")
+
+	// a full compile uses the updated sources
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !engine.HasTpl("footer") || engine.HasTpl("header") {
+		t.Errorf("Compile did not keep the updated templates")
+	}
+}
+
+func TestCompileTemplateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = "{{header}}"
+	engine.Raw.TemplateData["header"] = "a"
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				result, err := engine.ParseAndReturn(ctx, "main")
+				if err != nil {
+					t.Errorf("ParseAndReturn failed: %v", err)
+					return
+				}
+				if result != "a" && result != "b" {
+					t.Errorf("unexpected result %q", result)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		src := "a"
+		if j%2 == 0 {
+			src = "b"
+		}
+		if err := engine.CompileTemplate(ctx, "header", src); err != nil {
+			t.Fatalf("CompileTemplate failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestReloader(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	src := fstest.MapFS{
+		"tpl/_properties.json": {Data: []byte(`{}`)},
+		"tpl/main.tpl":         {Data: []byte("[{{header}}]"), ModTime: now},
+		"tpl/header.tpl":       {Data: []byte("v1"), ModTime: now},
+		"tpl/footer.tpl":       {Data: []byte("end"), ModTime: now},
+	}
+
+	engine := tpl.New()
+	if err := engine.Raw.FromVfs(src, "tpl"); err != nil {
+		t.Fatalf("FromVfs failed: %v", err)
+	}
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	r := tpl.NewReloader(engine, src, "tpl")
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	src["tpl/header.tpl"] = &fstest.MapFile{Data: []byte("v2"), ModTime: now.Add(time.Second)}
+	delete(src, "tpl/footer.tpl")
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	result, err := engine.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != "[v2]" {
+		t.Errorf("got %q, want %q", result, "[v2]")
+	}
+	if engine.HasTpl("footer") {
+		t.Errorf("deleted template was not removed")
+	}
+
+	// errors are reported once, and the previous version is kept
+	src["tpl/header.tpl"] = &fstest.MapFile{Data: []byte("{{/if}}"), ModTime: now.Add(2 * time.Second)}
+	if err := r.Check(ctx); err == nil {
+		t.Errorf("expected compile error")
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Errorf("unchanged file reported again: %v", err)
+	}
+	if result, _ := engine.ParseAndReturn(ctx, "main"); result != "[v2]" {
+		t.Errorf("got %q, want %q", result, "[v2]")
+	}
+}
+
+func TestReloaderBatch(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	src := fstest.MapFS{
+		"tpl/_properties.json": {Data: []byte(`{}`)},
+		"tpl/main.tpl":         {Data: []byte("main"), ModTime: now},
+	}
+
+	engine := tpl.New()
+	if err := engine.Raw.FromVfs(src, "tpl"); err != nil {
+		t.Fatalf("FromVfs failed: %v", err)
+	}
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	r := tpl.NewReloader(engine, src, "tpl")
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	check := func(name, expected string) {
+		t.Helper()
+		result, err := engine.ParseAndReturn(ctx, name)
+		if err != nil {
+			t.Fatalf("ParseAndReturn(%s) failed: %v", name, err)
+		}
+		if result != expected {
+			t.Errorf("got %q, want %q", result, expected)
+		}
+	}
+
+	// templates added together can include each other
+	src["tpl/a.tpl"] = &fstest.MapFile{Data: []byte("a{{include b}}"), ModTime: now}
+	src["tpl/b.tpl"] = &fstest.MapFile{Data: []byte("b{{include c}}"), ModTime: now}
+	src["tpl/c.tpl"] = &fstest.MapFile{Data: []byte("c"), ModTime: now}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	check("a", "abc")
+
+	// a failed change is applied once the missing template is added
+	src["tpl/d.tpl"] = &fstest.MapFile{Data: []byte("d{{include e}}"), ModTime: now}
+	if err := r.Check(ctx); err == nil {
+		t.Errorf("expected error for include of missing template")
+	}
+	if engine.HasTpl("d") {
+		t.Errorf("failed change was applied")
+	}
+	src["tpl/e.tpl"] = &fstest.MapFile{Data: []byte("e"), ModTime: now}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	check("d", "de")
+
+	// removing an included template with its includer
+	delete(src, "tpl/d.tpl")
+	delete(src, "tpl/e.tpl")
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if engine.HasTpl("d") || engine.HasTpl("e") {
+		t.Errorf("deleted templates were not removed")
+	}
+}
+
+func TestDirReloader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	write := func(name, data string, mod time.Time) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("_properties.json", "{}", now)
+	write("main.tpl", "v1", now)
+
+	engine := tpl.New()
+	if err := engine.Raw.FromDir(dir); err != nil {
+		t.Fatalf("FromDir failed: %v", err)
+	}
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	r := tpl.NewDirReloader(engine, dir)
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	write("main.tpl", "v2", now.Add(time.Second))
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	result, err := engine.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != "v2" {
+		t.Errorf("got %q, want %q", result, "v2")
+	}
+}
@@ -0,0 +1,54 @@
+package tpl
+
+// Trusted content values. Go code can pass them to templates, and filters
+// return them, to mark content that is safe in a given context: when
+// auto-escaping is enabled, they are written as is in that context instead
+// of being escaped. Elsewhere, they behave as strings.
+type (
+	// SafeHTML is HTML, written as is in HTML text. It is returned by the
+	// raw, entities, bbcode and markdown filters.
+	SafeHTML string
+	// SafeURL is a URL, written in URL attributes without checking its
+	// scheme or encoding its query.
+	SafeURL string
+	// SafeJS is JavaScript code, written as is in <script> and event
+	// attributes instead of being written as a JavaScript string.
+	SafeJS string
+	// SafeCSS is CSS code, written as is in <style> and style attributes.
+	SafeCSS string
+)
+
+// String returns the HTML
+func (s SafeHTML) String() string {
+	return string(s)
+}
+
+// String returns the URL
+func (s SafeURL) String() string {
+	return string(s)
+}
+
+// String returns the JavaScript code
+func (s SafeJS) String() string {
+	return string(s)
+}
+
+// String returns the CSS code
+func (s SafeCSS) String() string {
+	return string(s)
+}
+
+// safeString returns the content of v if it is a trusted content value
+func safeString(v any) (string, bool) {
+	switch s := v.(type) {
+	case SafeHTML:
+		return string(s), true
+	case SafeURL:
+		return string(s), true
+	case SafeJS:
+		return string(s), true
+	case SafeCSS:
+		return string(s), true
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestSafeValues(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_html": tpl.SafeHTML("<b>x</b>"),
+		"_url":  tpl.SafeURL("javascript:go(1)"),
+		"_js":   tpl.SafeJS("go(1)"),
+		"_css":  tpl.SafeCSS("rgb(1,2,3)"),
+		"_list": []any{tpl.SafeHTML("<i>")},
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"string", `{{_html}}{{_url|uppercase()}}`, "<b>x</b>JAVASCRIPT:GO(1)"},
+		{"length", `{{_html|length()}}`, "8"},
+		{"json", `{{_html|json()}} {{_list|json()}}`, `"\u003cb\u003ex\u003c/b\u003e" ["\u003ci\u003e"]`},
+		{"compare", `{{if _html == "<b>x</b>"}}equal{{/if}}`, "equal"},
+		{"bool", `{{if _js}}true{{/if}}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSafeValuesEscape(t *testing.T) {
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{
+		"_html": tpl.SafeHTML("<b>x</b>"),
+		"_url":  tpl.SafeURL("javascript:go(1)"),
+		"_js":   tpl.SafeJS("go(1)"),
+		"_css":  tpl.SafeCSS("rgb(1,2,3)"),
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"html", `{{_html}}<i title="{{_html}}">`, `<b>x</b><i title="&lt;b&gt;x&lt;/b&gt;">`},
+		{"url", `<a href="{{_url}}">{{_url}}</a>`, `<a href="javascript:go(1)">javascript:go(1)</a>`},
+		{"url_query", `<a href="/?{{_url}}">`, `<a href="/?javascript:go(1)">`},
+		{"url_as_html", `<a href="{{_html}}"><a href="{{_url|raw()}}">`, `<a href="%3Cb%3Ex%3C/b%3E"><a href="#ZtplZ">`},
+		{"js", `<a onclick="{{_js}}"><script>{{_js}}; x = '{{_js}}'</script>`, `<a onclick="go(1)"><script>go(1); x = 'go(1)'</script>`},
+		{"js_as_html", `<script>{{_html}}</script>`, `<script>"\u003cb\u003ex\u003c\/b\u003e"</script>`},
+		{"css", `<p style="color: {{_css}}">`, `<p style="color: rgb(1,2,3)">`},
+		{"css_as_js", `<p style="color: {{_js}}">`, `<p style="color: go\28 1\29 ">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.AutoEscape = true
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
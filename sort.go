@@ -0,0 +1,306 @@
+package tpl
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func init() {
+	RegisterFilter("sortkeys", fltSortKeys)
+	RegisterFilter("sortvalues", fltSortValues)
+}
+
+// orderedMap is a list of key/value pairs kept in a given order, as returned
+// by the sort filters
+type orderedMap struct {
+	keys   []any
+	values []any
+	list   bool // sorted from a list, keys are the indexes in the list
+}
+
+// String returns the elements like fmt does for a map or a slice
+func (m *orderedMap) String() string {
+	if m.list {
+		return fmt.Sprint(m.values)
+	}
+	b := &strings.Builder{}
+	b.WriteString("map[")
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "%v:%v", k, m.values[i])
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// MarshalJSON returns an array of the values if m was sorted from a list,
+// or an object with the keys in order
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	if m.list {
+		return json.Marshal(m.values)
+	}
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(fmt.Sprint(k))
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// slice returns the elements of m from index from to index to, excluded
+func (m *orderedMap) slice(from, to int) *orderedMap {
+	return &orderedMap{keys: m.keys[from:to], values: m.values[from:to], list: m.list}
+}
+
+// reverse returns the elements of m in reverse order
+func (m *orderedMap) reverse() *orderedMap {
+	r := &orderedMap{keys: slices.Clone(m.keys), values: slices.Clone(m.values), list: m.list}
+	slices.Reverse(r.keys)
+	slices.Reverse(r.values)
+	return r
+}
+
+// All calls f for each element in order, until f returns false
+func (m *orderedMap) All(f func(key, value any) bool) bool {
+	for i, k := range m.keys {
+		if !f(k, m.values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the number of elements
+func (m *orderedMap) Size() int {
+	return len(m.keys)
+}
+
+func fltSortKeys(ctx context.Context, params Values, in Value, out WritableValue) error {
+	return sortFilter(ctx, "sortkeys", params, in, out, true)
+}
+
+func fltSortValues(ctx context.Context, params Values, in Value, out WritableValue) error {
+	return sortFilter(ctx, "sortvalues", params, in, out, false)
+}
+
+// sortFilter returns the elements of in sorted by key or by value. Params
+// can be "asc" or "desc" for the order, and "natural" and/or "locale" for
+// the way strings are compared.
+func sortFilter(ctx context.Context, name string, params Values, in Value, out WritableValue, byKey bool) error {
+	inObj, err := in.WithCtx(ctx).Raw()
+	if err != nil {
+		return err
+	}
+
+	var desc, natural, locale bool
+	for _, p := range params {
+		switch opt := p.WithCtx(ctx).String(); strings.ToLower(opt) {
+		case "asc":
+			desc = false
+		case "desc":
+			desc = true
+		case "natural":
+			natural = true
+		case "locale":
+			locale = true
+		default:
+			return fmt.Errorf("%s(): unknown option %s", name, opt)
+		}
+	}
+
+	res := &orderedMap{}
+	switch inObj.(type) {
+	case Values, []any, []string:
+		res.list = true
+	}
+	_, err = foreachAny(ctx, inObj, func(k, v any, idx, max int64) error {
+		res.keys = append(res.keys, k)
+		res.values = append(res.values, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	strCmp := strings.Compare
+	switch {
+	case locale:
+		var opts []collate.Option
+		if natural {
+			opts = append(opts, collate.Numeric)
+		}
+		strCmp = newCollator(ctx, opts...).CompareString
+	case natural:
+		strCmp = naturalCompare
+	}
+
+	src := res.values
+	if byKey {
+		src = res.keys
+	}
+	sortVals := make([]sortValue, len(src))
+	for i, v := range src {
+		sortVals[i] = newSortValue(ctx, v)
+	}
+
+	order := make([]int, len(src))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		c := sortVals[a].compare(sortVals[b], strCmp)
+		if desc {
+			return -c
+		}
+		return c
+	})
+
+	sorted := &orderedMap{keys: make([]any, len(order)), values: make([]any, len(order)), list: res.list}
+	for i, j := range order {
+		sorted.keys[i] = res.keys[j]
+		sorted.values[i] = res.values[j]
+	}
+	return out.WriteValue(ctx, sorted)
+}
+
+// newCollator returns a collator for the language of the context, or for the
+// root locale if none is set
+func newCollator(ctx context.Context, opts ...collate.Option) *collate.Collator {
+	lng, ok := ctx.Value("_language").(language.Tag)
+	if !ok {
+		lng = language.Und
+	}
+	return collate.New(lng, opts...)
+}
+
+// collators keeps a pool of collators for each language, as building one is
+// slow and a collator cannot be used by several goroutines at once
+var collators sync.Map // language.Tag → *sync.Pool
+
+// compareCollated compares strings using a collator for the language of the
+// context, taken from collators
+func compareCollated(ctx context.Context, s1, s2 string) int {
+	lng, ok := ctx.Value("_language").(language.Tag)
+	if !ok {
+		lng = language.Und
+	}
+	p, ok := collators.Load(lng)
+	if !ok {
+		p, _ = collators.LoadOrStore(lng, &sync.Pool{New: func() any { return collate.New(lng) }})
+	}
+	pool := p.(*sync.Pool)
+	c := pool.Get().(*collate.Collator)
+	defer pool.Put(c)
+	return c.CompareString(s1, s2)
+}
+
+// sortValue is a value prepared for sorting. Numbers are sorted before
+// strings and compared by value.
+type sortValue struct {
+	isNum bool
+	num   float64
+	str   string
+}
+
+func newSortValue(ctx context.Context, v any) sortValue {
+	raw, err := NewValue(v).WithCtx(ctx).Raw()
+	if err != nil {
+		return sortValue{}
+	}
+	switch n := raw.(type) {
+	case int:
+		return sortValue{isNum: true, num: float64(n)}
+	case int8:
+		return sortValue{isNum: true, num: float64(n)}
+	case int16:
+		return sortValue{isNum: true, num: float64(n)}
+	case int32:
+		return sortValue{isNum: true, num: float64(n)}
+	case int64:
+		return sortValue{isNum: true, num: float64(n)}
+	case uint:
+		return sortValue{isNum: true, num: float64(n)}
+	case uint8:
+		return sortValue{isNum: true, num: float64(n)}
+	case uint16:
+		return sortValue{isNum: true, num: float64(n)}
+	case uint32:
+		return sortValue{isNum: true, num: float64(n)}
+	case uint64:
+		return sortValue{isNum: true, num: float64(n)}
+	case float32:
+		return sortValue{isNum: true, num: float64(n)}
+	case float64:
+		return sortValue{isNum: true, num: n}
+	}
+	return sortValue{str: NewValue(raw).WithCtx(ctx).String()}
+}
+
+func (a sortValue) compare(b sortValue, strCmp func(a, b string) int) int {
+	switch {
+	case a.isNum && b.isNum:
+		return cmp.Compare(a.num, b.num)
+	case a.isNum:
+		return -1
+	case b.isNum:
+		return 1
+	}
+	return strCmp(a.str, b.str)
+}
+
+// naturalCompare compares strings with sequences of digits compared by their
+// numeric value, so "item2" comes before "item10"
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitsLen(a), digitsLen(b)
+			da, db := strings.TrimLeft(a[:na], "0"), strings.TrimLeft(b[:nb], "0")
+			if len(da) != len(db) {
+				return cmp.Compare(len(da), len(db))
+			}
+			if c := strings.Compare(da, db); c != 0 {
+				return c
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func digitsLen(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,165 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestStrictCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		message  string // empty if compile should succeed
+		line     int
+		char     int
+	}{
+		{"known_references", `{{_x|uppercase()}}{{@seq(1, 2)|json()}}{{other}}{{other/a}}`, "", 0, 0},
+		{"undefined_filter", `{{_x|nosuchfilter()}}`, "call to undefined filter nosuchfilter", 1, 5},
+		{"undefined_function", `a {{@nosuchfunc()}}`, "call to undefined function @nosuchfunc", 1, 3},
+		{"undefined_template", `a {{nosuchtpl}}`, "undefined template nosuchtpl", 1, 3},
+		{"undefined_template_in_if", "{{if 1}}\n{{nosuchtpl/a}}{{/if}}", "undefined template nosuchtpl", 2, 1},
+		{"filter_in_expression", `{{(_x|nosuchfilter() .. "a")}}`, "call to undefined filter nosuchfilter", 1, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Strict = true
+			engine.Raw.TemplateData["main"] = tt.template
+			engine.Raw.TemplateData["other"] = "other"
+
+			err := engine.Compile(context.Background())
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("Compile failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %T", err)
+			}
+			if !strings.Contains(e.Message, tt.message) {
+				t.Errorf("got message %q, want %q", e.Message, tt.message)
+			}
+			if e.Line != tt.line || e.Char != tt.char {
+				t.Errorf("got error at %d:%d, want %d:%d (%s)", e.Line, e.Char, tt.line, tt.char, e)
+			}
+
+			// the same page compiles without strict mode
+			engine.Strict = false
+			if err := engine.Compile(context.Background()); err != nil {
+				t.Errorf("non-strict Compile failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestStrictCompileContextFunction(t *testing.T) {
+	engine := tpl.New()
+	engine.Strict = true
+	engine.Raw.TemplateData["main"] = `{{@ctxfunc()}}`
+
+	ctx := context.WithValue(context.Background(), "@ctxfunc", tpl.TplFuncCallback(func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+		return out.WriteValue(ctx, "from ctx")
+	}))
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	result, err := engine.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != "from ctx" {
+		t.Errorf("got %q, want %q", result, "from ctx")
+	}
+}
+
+func TestStrictRun(t *testing.T) {
+	vars := map[string]any{
+		"_x":    "X",
+		"_null": nil,
+		"_list": []any{"a", "b"},
+		"_map":  map[string]any{"a": "A", "none": nil},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		message  string // expected error, if any
+	}{
+		{"defined", `{{_x}}{{_list/1}}{{_map/a}}{{_list[-1]}}`, "XbAb", ""},
+		{"null_index", `[{{_map/none}}]`, "[]", ""},
+		{"null_variable", `{{_null}}`, "", "undefined variable _null"},
+		{"null_variable_default", `{{(_null ?? "default")}}`, "default", ""},
+		{"undefined_variable", `{{_y}}`, "", "undefined variable _y"},
+		{"undefined_in_condition", `{{if _y}}a{{/if}}`, "", "undefined variable _y"},
+		{"undefined_path", `{{_map/b}}`, "", "undefined index b"},
+		{"out_of_range", `{{_list/2}}`, "", "undefined index 2"},
+		{"out_of_range_bracket", `{{(_list[5])}}`, "", "undefined index 5"},
+		{"out_of_range_string", `{{("abc"[3])}}`, "", "undefined index 3"},
+		{"default_undefined", `{{(_y ?? "default")}}`, "default", ""},
+		{"default_undefined_index", `{{(_map/b ?? "default")}}{{(_list[9] ?? "!")}}`, "default!", ""},
+		{"default_defined", `{{(_x ?? "default")}}`, "X", ""},
+		{"default_right_side", `{{(_y ?? _z)}}`, "", "undefined variable _z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Strict = true
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("ParseAndReturn failed: %v", err)
+				}
+				if result != tt.expected {
+					t.Errorf("got %q, want %q", result, tt.expected)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, got %q", result)
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %T", err)
+			}
+			if !strings.Contains(e.Error(), tt.message) {
+				t.Errorf("got error %q, want %q", e, tt.message)
+			}
+		})
+	}
+}
+
+func TestNonStrictUndefined(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `[{{_y}}{{_list/5}}{{nosuchtpl}}]`
+
+	ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_list": []any{"a"}})
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	result, err := engine.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != "[]" {
+		t.Errorf("got %q, want %q", result, "[]")
+	}
+}
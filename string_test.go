@@ -0,0 +1,92 @@
+package tpl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"newline", `{{"a\nb"}}`, "a\nb"},
+		{"tab_cr", `{{"a\tb\r"}}`, "a\tb\r"},
+		{"backslash", `{{"a\\b"}}`, `a\b`},
+		{"quote", `{{"say \"hi\""}}`, `say "hi"`},
+		{"unicode", `{{"caf\u00e9"}}`, "café"},
+		{"hex", `{{"\x41\x42"}}`, "AB"},
+		{"unknown_kept", `{{"\d+"}}`, `\d+`},
+		{"incomplete_unicode_kept", `{{"\u00"}}`, `\u00`},
+		{"incomplete_hex_kept", `{{"\xZ"}}`, `\xZ`},
+		{"escaped_tag", `{{"\{{_x}}"}}`, "{{_x}}"},
+		{"backslash_before_tag", `{{"\\{{_x}}"}}`, `\X`},
+		{"single_quote", `{{'hello'}}`, "hello"},
+		{"single_quote_escape", `{{'it\'s'}}`, "it's"},
+		{"single_quote_with_double", `{{'say "hi"'}}`, `say "hi"`},
+		{"double_quote_with_single", `{{"it's"}}`, "it's"},
+		{"single_quote_variable", `{{'value: {{_x}}'}}`, "value: X"},
+		{"filter_param", `{{_list|implode("\n")}}`, "a\nb"},
+		{"single_quote_param", `{{_list|implode(', ')}}`, "a, b"},
+		{"comparison", `{{if _x == 'X'}}yes{{/if}}`, "yes"},
+		{"hex_comparison", `{{if "\x58" == _x}}yes{{/if}}`, "yes"},
+		{"outside_tag", `a\nb`, `a\nb`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_x": "X", "_list": []string{"a", "b"}})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStringEscapeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		line     int
+		char     int
+	}{
+		{"plain", `{{"abcdef"}} {{/if}}`, 1, 14},
+		{"escapes", `{{"\n\t\\"}} {{/if}}`, 1, 14},
+		{"multiline", "{{\"a\\nb\"}}\n  {{/if}}", 2, 3},
+		{"unclosed_single_quote", `{{'abc}}`, 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+
+			err := engine.Compile(context.Background())
+			if err == nil {
+				t.Fatalf("expected compile error")
+			}
+			var e *tpl.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *tpl.Error, got %T", err)
+			}
+			if e.Line != tt.line || e.Char != tt.char {
+				t.Errorf("got error at %d:%d, want %d:%d (%s)", e.Line, e.Char, tt.line, tt.char, e)
+			}
+		})
+	}
+}
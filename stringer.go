@@ -25,11 +25,23 @@ func _() {
 	_ = x[internalList-14]
 	_ = x[internalSet-15]
 	_ = x[internalIndex-16]
+	_ = x[internalBlock-17]
+	_ = x[internalExtends-18]
+	_ = x[internalParent-19]
+	_ = x[internalInclude-20]
+	_ = x[internalParams-21]
+	_ = x[internalSwitch-22]
+	_ = x[internalCase-23]
+	_ = x[internalBreak-24]
+	_ = x[internalContinue-25]
+	_ = x[internalArrayLit-26]
+	_ = x[internalMapLit-27]
+	_ = x[internalSlice-28]
 }
 
-const _internalType_name = "internalInvalidinternalTextinternalLinkinternalQuoteinternalValueinternalIfinternalTryinternalForeachinternalJsinternalFuncinternalFilterinternalVarinternalOperatorinternalSubinternalListinternalSetinternalIndex"
+const _internalType_name = "internalInvalidinternalTextinternalLinkinternalQuoteinternalValueinternalIfinternalTryinternalForeachinternalJsinternalFuncinternalFilterinternalVarinternalOperatorinternalSubinternalListinternalSetinternalIndexinternalBlockinternalExtendsinternalParentinternalIncludeinternalParamsinternalSwitchinternalCaseinternalBreakinternalContinueinternalArrayLitinternalMapLitinternalSlice"
 
-var _internalType_index = [...]uint8{0, 15, 27, 39, 52, 65, 75, 86, 101, 111, 123, 137, 148, 164, 175, 187, 198, 211}
+var _internalType_index = [...]uint16{0, 15, 27, 39, 52, 65, 75, 86, 101, 111, 123, 137, 148, 164, 175, 187, 198, 211, 224, 239, 253, 268, 282, 296, 308, 321, 337, 353, 367, 380}
 
 func (i internalType) String() string {
 	idx := int(i) - 0
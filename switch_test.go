@@ -0,0 +1,104 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestSwitch(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"first_case", `{{switch {{_s}}}}{{case "a"}}A{{case "b"}}B{{/switch}}`, map[string]any{"_s": "a"}, "A"},
+		{"second_case", `{{switch {{_s}}}}{{case "a"}}A{{case "b"}}B{{/switch}}`, map[string]any{"_s": "b"}, "B"},
+		{"no_match", `[{{switch {{_s}}}}{{case "a"}}A{{case "b"}}B{{/switch}}]`, map[string]any{"_s": "c"}, "[]"},
+		{"default", `{{switch {{_s}}}}{{case "a"}}A{{default}}other{{/switch}}`, map[string]any{"_s": "z"}, "other"},
+		{"only_default", `{{switch {{_s}}}}{{default}}D{{/switch}}`, map[string]any{"_s": "z"}, "D"},
+		{"multiple_values", `{{switch {{_s}}}}{{case "pending","processing"}}wait{{case "done"}}ok{{/switch}}`, map[string]any{"_s": "processing"}, "wait"},
+		{"loose_number", `{{switch {{_n}}}}{{case 1}}one{{case 2}}two{{/switch}}`, map[string]any{"_n": "2"}, "two"},
+		{"loose_string", `{{switch {{_n}}}}{{case "3"}}three{{/switch}}`, map[string]any{"_n": 3}, "three"},
+		{"expression_subject", `{{switch ({{_n}} + 1)}}{{case 3}}three{{/switch}}`, map[string]any{"_n": 2}, "three"},
+		{"variable_case", `{{switch {{_s}}}}{{case {{_v}}}}same{{default}}diff{{/switch}}`, map[string]any{"_s": "x", "_v": "x"}, "same"},
+		{"whitespace_ignored", "{{switch {{_s}}}}\n  {{case \"a\"}}A{{/switch}}", map[string]any{"_s": "a"}, "A"},
+		{"nested", `{{switch {{_s}}}}{{case "a"}}{{switch {{_n}}}}{{case 1}}a1{{/switch}}{{/switch}}`, map[string]any{"_s": "a", "_n": 1}, "a1"},
+		{"with_if", `{{switch {{_s}}}}{{case "a"}}{{if 1}}yes{{else}}no{{/if}}{{default}}d{{/switch}}`, map[string]any{"_s": "a"}, "yes"},
+		{"trim_blocks", "{{switch {{_s}}}}\n{{case \"a\"}}\nA\n{{default}}\nD\n{{/switch}}\n", map[string]any{"_s": "a"}, "A\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.TrimBlocks = tt.name == "trim_blocks"
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), tt.vars)
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSwitchSubjectEvaluatedOnce(t *testing.T) {
+	count := 0
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = `{{switch {{@counter()}}}}{{case 5}}five{{case 6}}six{{case 1}}one{{/switch}}`
+
+	counter := func(ctx context.Context, params tpl.Values, out tpl.WritableValue) error {
+		count++
+		return out.WriteValue(ctx, count)
+	}
+	ctx := context.WithValue(context.Background(), "@counter", tpl.TplFuncCallback(counter)) //lint:ignore SA1029 template functions use string keys by design
+	if err := engine.Compile(ctx); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	result, err := engine.ParseAndReturn(ctx, "main")
+	if err != nil {
+		t.Fatalf("ParseAndReturn failed: %v", err)
+	}
+	if result != "one" || count != 1 {
+		t.Errorf("got %q with %d evaluations, want \"one\" with 1", result, count)
+	}
+}
+
+func TestSwitchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"case_outside", `{{case "a"}}x`},
+		{"default_outside", `{{default}}x`},
+		{"case_in_if", `{{switch 1}}{{if 1}}{{case 1}}{{/if}}{{/switch}}`},
+		{"case_after_default", `{{switch 1}}{{default}}x{{case 1}}y{{/switch}}`},
+		{"default_twice", `{{switch 1}}{{default}}x{{default}}y{{/switch}}`},
+		{"content_before_case", `{{switch 1}}text{{case 1}}y{{/switch}}`},
+		{"content_no_case", `{{switch 1}}text{{/switch}}`},
+		{"case_without_value", `{{switch 1}}{{case}}y{{/switch}}`},
+		{"switch_without_value", `{{switch}}{{case 1}}y{{/switch}}`},
+		{"not_closed", `{{switch 1}}{{case 1}}y`},
+		{"close_outside", `{{/switch}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.Raw.TemplateData["main"] = tt.template
+			if err := engine.Compile(context.Background()); err == nil {
+				t.Errorf("expected compile error")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package tpl_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/KarpelesLab/tpl"
+)
+
+func TestTrimMarkers(t *testing.T) {
+	tests := []struct {
+		name       string
+		template   string
+		trimBlocks bool
+		expected   string
+	}{
+		{"trim_left", "a  \n  {{- _x}}  b", false, "aX  b"},
+		{"trim_right", "a  {{_x -}}  \n  b", false, "a  Xb"},
+		{"trim_both", "a  \n  {{- _x -}}  \n  b", false, "aXb"},
+		{"trim_block_tags", "{{- if 1 -}}\n  yes\n{{- /if}}", false, "yes"},
+		{"trim_filter", "{{_x|lowercase() -}}  z", false, "xz"},
+		{"negative_number", "{{-5}}", false, "-5"},
+		{"minus_expression", "{{(5 -3)}}", false, "2"},
+		{"literal_kept", "{{literal}}x  {{/literal}}{{- _x}}", false, "x  X"},
+		{"escape_kept", "\\{{  {{- _x}}", false, "{{X"},
+		{"no_trim_blocks", "[\n  {{if 1}}\n  x\n  {{/if}}\n]", false, "[\n  \n  x\n  \n]"},
+		{"trim_blocks", "[\n  {{if 1}}\n  x\n  {{else}}\n  y\n  {{/if}}\n]", true, "[\n  x\n]"},
+		{"trim_blocks_foreach", "[\n{{foreach {{_l}} as _v}}\n  {{_v}},\n{{/foreach}}\n]", true, "[\n  1,\n  2,\n]"},
+		{"trim_blocks_set", "{{set _y=\"Y\"}}\n{{_y}}\n{{/set}}\n", true, "Y\n"},
+		{"trim_blocks_not_alone", "{{if 1}} x {{/if}}\n", true, " x \n"},
+		{"trim_blocks_variable", "  {{_x}}\n", true, "  X\n"},
+		{"trim_blocks_crlf", "{{if 1}}\r\nx\r\n{{/if}}\r\n", true, "x\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := tpl.New()
+			engine.TrimBlocks = tt.trimBlocks
+			engine.Raw.TemplateData["main"] = tt.template
+
+			ctx := tpl.ValuesCtx(context.Background(), map[string]any{"_x": "X", "_l": []any{1, 2}})
+			if err := engine.Compile(ctx); err != nil {
+				t.Fatalf("Compile failed: %v", err)
+			}
+
+			result, err := engine.ParseAndReturn(ctx, "main")
+			if err != nil {
+				t.Fatalf("ParseAndReturn failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrimMarkersLineNumbers(t *testing.T) {
+	engine := tpl.New()
+	engine.Raw.TemplateData["main"] = "{{_x -}}\n\n\n{{@nosuchfunction(1)}}"
+
+	err := engine.Compile(context.Background())
+	if err == nil {
+		// unknown functions are only reported at runtime
+		_, err = engine.ParseAndReturn(context.Background(), "main")
+	}
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	e, ok := err.(*tpl.Error)
+	if !ok {
+		t.Fatalf("expected *tpl.Error, got %T", err)
+	}
+	if e.Line != 4 {
+		t.Errorf("expected error on line 4, got line %d", e.Line)
+	}
+}
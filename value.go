@@ -145,6 +145,9 @@ func (v *ValueCtx) StringErr() (string, error) {
 		return string(rv), nil
 	case *ValueCtx:
 		return rv.String(), nil
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		str, _ := safeString(rv)
+		return str, nil
 	case fmt.Stringer:
 		return rv.String(), nil
 	case bytableIf:
@@ -223,6 +226,9 @@ func (v *ValueCtx) BytesErr() ([]byte, error) {
 		return []byte(strconv.FormatFloat(rv, 'g', 14, 64)), nil
 	case *ValueCtx:
 		return rv.Bytes(), nil
+	case SafeHTML, SafeURL, SafeJS, SafeCSS:
+		str, _ := safeString(rv)
+		return []byte(str), nil
 	case bytableIf:
 		return rv.Bytes(), nil
 	case fmt.Stringer: